@@ -0,0 +1,32 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const keyCacheHit = "db.cache.hit"
+
+// TraceCacheHit emits a span of the QueryTypeCacheHit type, tagged with db.cache.hit, for a query
+// which was served by an application-level cache instead of the database, so that cache hits show up
+// in the same traces as the queries which reached the database. The span is configured like the ones
+// of a database opened using Open with the given driver name and options: the configuration passed
+// to Register is used, and WithIgnoreQueryTypes can exclude cache hits.
+func TraceCacheHit(ctx context.Context, driverName, query string, opts ...Option) {
+	cfg := new(config)
+	rc, _ := registeredDrivers.config(driverName)
+	defaults(cfg, driverName, rc)
+	for _, fn := range opts {
+		fn(cfg)
+	}
+	cfg.startOpts = cfg.staticSpanOptions(driverName)
+	tp := &traceParams{cfg: cfg, driverName: driverName}
+	tp.tryTrace(ctx, QueryTypeCacheHit, query, time.Now(), nil, tracer.Tag(keyCacheHit, true))
+}
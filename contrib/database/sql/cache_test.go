@@ -0,0 +1,48 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceCacheHit(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	Register("test", &internal.MockDriver{}, WithServiceName("test-db"))
+	defer unregister("test")
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "request")
+	TraceCacheHit(WithSpanTags(ctx, map[string]string{"user": "u1"}), "test", "SELECT * FROM users WHERE id = ?")
+	TraceCacheHit(WithQueryName(ctx, "get_user"), "test", "SELECT * FROM users WHERE id = ?", WithSuppressTags(ext.Component))
+	TraceCacheHit(ctx, "test", "SELECT 1", WithIgnoreQueryTypes(QueryTypeCacheHit))
+	root.Finish()
+
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeCacheHit)
+	require.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, "test.query", s.OperationName())
+		assert.Equal(t, "test-db", s.Tag(ext.ServiceName))
+		assert.Equal(t, root.Context().SpanID(), s.ParentID())
+		assert.Equal(t, true, s.Tag(keyCacheHit))
+		assert.Equal(t, ext.SpanTypeSQL, s.Tag(ext.SpanType))
+	}
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", spans[0].Tag(ext.ResourceName))
+	assert.Equal(t, "u1", spans[0].Tag("user"))
+	assert.Equal(t, componentName, spans[0].Tag(ext.Component))
+	assert.Equal(t, "get_user", spans[1].Tag(ext.ResourceName))
+	assert.Nil(t, spans[1].Tag(ext.Component))
+}
@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	keyCloseWaitNs      = "db.close.wait_ns"
+	keyCloseTimedOut    = "db.close.timed_out"
+	keyCloseConnections = "db.close.connections_in_use"
+)
+
+// closePollInterval is the interval at which CloseWithContext checks whether connections are in use.
+var closePollInterval = 10 * time.Millisecond
+
+// CloseWithContext closes db, opened using Open or OpenDB, once all of its connections are released,
+// so that in-flight queries aren't interrupted, or once ctx is done, whichever comes first. It emits a
+// span of the QueryTypeDBClose type, which can be excluded using WithIgnoreQueryTypes, tagged with the
+// time spent waiting in nanoseconds as db.close.wait_ns, with db.close.timed_out set to true if ctx was
+// done first and with the number of connections still in use at that point as
+// db.close.connections_in_use. No span is emitted if no connection of db could be obtained to look
+// up its configuration. It returns the error of (*sql.DB).Close.
+func CloseWithContext(ctx context.Context, db *sql.DB) error {
+	var tp *traceParams
+	if conn, err := db.Conn(ctx); err == nil {
+		conn.Raw(func(dc interface{}) error {
+			if c, ok := dc.(*TracedConn); ok {
+				tp = c.traceParams
+			}
+			return nil
+		})
+		conn.Close()
+	}
+	start := time.Now()
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+	var opts []ddtrace.StartSpanOption
+	timedOut := false
+	for inUse := db.Stats().InUse; inUse > 0; inUse = db.Stats().InUse {
+		select {
+		case <-ctx.Done():
+			timedOut = true
+			opts = append(opts, tracer.Tag(keyCloseConnections, inUse))
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	opts = append(opts,
+		tracer.Tag(keyCloseWaitNs, int64(time.Since(start))),
+		tracer.Tag(keyCloseTimedOut, timedOut),
+	)
+	err := db.Close()
+	if tp != nil {
+		err = tp.tryTrace(ctx, QueryTypeDBClose, "", start, err, opts...)
+	}
+	return err
+}
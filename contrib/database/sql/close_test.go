@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseWithContext(t *testing.T) {
+	Register("test", &internal.MockDriver{})
+	defer unregister("test")
+
+	closeSpan := func(t *testing.T, mt mocktracer.Tracer) mocktracer.Span {
+		spans := spansOfType(mt.FinishedSpans(), QueryTypeDBClose)
+		require.Len(t, spans, 1)
+		return spans[0]
+	}
+
+	t.Run("released", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		db, err := Open("test", "dn", WithServiceName("test-db"))
+		require.NoError(t, err)
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			conn.Close()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		require.NoError(t, CloseWithContext(ctx, db))
+
+		s := closeSpan(t, mt)
+		assert.Equal(t, "test-db", s.Tag(ext.ServiceName))
+		assert.Equal(t, "test.query", s.OperationName())
+		assert.Equal(t, false, s.Tag(keyCloseTimedOut))
+		assert.Nil(t, s.Tag(keyCloseConnections))
+		assert.GreaterOrEqual(t, s.Tag(keyCloseWaitNs), int64(20*time.Millisecond))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		db, err := Open("test", "dn")
+		require.NoError(t, err)
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.NoError(t, CloseWithContext(ctx, db))
+
+		s := closeSpan(t, mt)
+		assert.Equal(t, true, s.Tag(keyCloseTimedOut))
+		assert.Equal(t, 1, s.Tag(keyCloseConnections))
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		db, err := Open("test", "dn", WithIgnoreQueryTypes(QueryTypeDBClose))
+		require.NoError(t, err)
+		require.NoError(t, CloseWithContext(context.Background(), db))
+
+		assert.Empty(t, spansOfType(mt.FinishedSpans(), QueryTypeDBClose))
+	})
+
+	t.Run("untraced", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		db := sql.OpenDB(&dsnConnector{dsn: "dn", driver: &internal.MockDriver{}})
+		require.NoError(t, CloseWithContext(context.Background(), db))
+
+		assert.Empty(t, mt.FinishedSpans())
+	})
+}
@@ -8,8 +8,14 @@ package sql // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
 import (
 	"context"
 	"database/sql/driver"
-	"math"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -41,10 +47,50 @@ const (
 	QueryTypeCommit = "Commit"
 	// QueryTypeRollback is used for Rollback traces.
 	QueryTypeRollback = "Rollback"
+	// QueryTypeHealth is used for the traces of Health.
+	QueryTypeHealth = "Health"
+	// QueryTypeDBClose is used for the traces of CloseWithContext.
+	QueryTypeDBClose = "DBClose"
+	// QueryTypeCacheHit is used for the traces of TraceCacheHit.
+	QueryTypeCacheHit = "CacheHit"
+	// QueryTypeSessionConfig is used for traces of session configuration statements, such as SET,
+	// when enabled using WithSessionConfigQueryType.
+	QueryTypeSessionConfig = "SessionConfig"
 )
 
 const (
-	keyDBMTraceInjected = "_dd.dbm_trace_injected"
+	keyDBMTraceInjected     = "_dd.dbm_trace_injected"
+	keyDBMInjectionError    = "_dd.dbm_injection_error"
+	keySerializationFailure = "db.serialization_failure"
+	keyResultColumnCount    = "db.result.column_count"
+	keyResultColumnTypes    = "db.result.column_types"
+	keyInstanceName         = "db.instance_name"
+	keyQueryName            = "db.query_name"
+	keyCommitSlow           = "db.commit.slow"
+	keyLinkedTraceID        = "db.linked.trace_id"
+	keyLinkedSpanID         = "db.linked.span_id"
+	keyInTransaction        = "db.in_transaction"
+	keySQLTable             = "db.sql.table"
+	keyStatementTimeout     = "db.statement_timeout.exceeded"
+	keyDBMDanglingRisk      = "db.dbm.dangling_risk"
+	keyDBMOrphan            = "db.dbm.orphan"
+	keyPoolID               = "db.pool.id"
+	keyStmtExecutions       = "db.prepared_statement.executions"
+	keyFullTableMutation    = "db.full_table_mutation"
+	keyOperation            = "db.app_operation"
+	keyParamsHash           = "db.params.hash"
+)
+
+const (
+	// sqlStateSerializationFailure is the SQLSTATE returned when a transaction could not be serialized.
+	sqlStateSerializationFailure = "40001"
+	// sqlStateQueryCanceled is the SQLSTATE returned when a statement is canceled, either by the
+	// server when statement_timeout is exceeded, or on request of the client, e.g. when its
+	// context is done.
+	sqlStateQueryCanceled = "57014"
+	// statementTimeoutMessage is the error message of statements canceled because statement_timeout
+	// was exceeded.
+	statementTimeoutMessage = "canceling statement due to statement timeout"
 )
 
 // TracedConn holds a traced connection with tracing parameters.
@@ -74,19 +120,21 @@ func (tc *TracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx dr
 		ctx, end := startTraceTask(ctx, QueryTypeBegin)
 		defer end()
 		tx, err = connBeginTx.BeginTx(ctx, opts)
-		tc.tryTrace(ctx, QueryTypeBegin, "", start, err)
+		err = tc.tryTrace(ctx, QueryTypeBegin, "", start, err)
 		if err != nil {
 			return nil, err
 		}
+		tc.inTx = true
 		return &tracedTx{tx, tc.traceParams, ctx}, nil
 	}
 	ctx, end := startTraceTask(ctx, QueryTypeBegin)
 	defer end()
 	tx, err = tc.Conn.Begin()
-	tc.tryTrace(ctx, QueryTypeBegin, "", start, err)
+	err = tc.tryTrace(ctx, QueryTypeBegin, "", start, err)
 	if err != nil {
 		return nil, err
 	}
+	tc.inTx = true
 	return &tracedTx{tx, tc.traceParams, ctx}, nil
 }
 
@@ -100,17 +148,19 @@ func (tc *TracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx dr
 // execution of the statement.
 func (tc *TracedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
 	start := time.Now()
-	mode := tc.cfg.dbmPropagationMode
-	if mode == tracer.DBMPropagationModeFull {
+	mode := tc.propagationMode(QueryTypePrepare)
+	if tc.cfg.preparedStatementDBMDisabled {
+		mode = tracer.DBMPropagationModeDisabled
+	} else if mode == tracer.DBMPropagationModeFull {
 		// no context other than service in prepared statements
 		mode = tracer.DBMPropagationModeService
 	}
-	cquery, spanID := tc.injectComments(ctx, query, mode)
+	cquery, opts := tc.injectComments(ctx, query, mode)
 	if connPrepareCtx, ok := tc.Conn.(driver.ConnPrepareContext); ok {
 		ctx, end := startTraceTask(ctx, QueryTypePrepare)
 		defer end()
 		stmt, err := connPrepareCtx.PrepareContext(ctx, cquery)
-		tc.tryTrace(ctx, QueryTypePrepare, query, start, err, append(withDBMTraceInjectedTag(mode), tracer.WithSpanID(spanID))...)
+		err = tc.tryTrace(ctx, QueryTypePrepare, query, start, err, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -119,7 +169,7 @@ func (tc *TracedConn) PrepareContext(ctx context.Context, query string) (stmt dr
 	ctx, end := startTraceTask(ctx, QueryTypePrepare)
 	defer end()
 	stmt, err = tc.Prepare(cquery)
-	tc.tryTrace(ctx, QueryTypePrepare, query, start, err, append(withDBMTraceInjectedTag(mode), tracer.WithSpanID(spanID))...)
+	err = tc.tryTrace(ctx, QueryTypePrepare, query, start, err, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -131,11 +181,14 @@ func (tc *TracedConn) PrepareContext(ctx context.Context, query string) (stmt dr
 func (tc *TracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (r driver.Result, err error) {
 	start := time.Now()
 	if execContext, ok := tc.Conn.(driver.ExecerContext); ok {
-		cquery, spanID := tc.injectComments(ctx, query, tc.cfg.dbmPropagationMode)
-		ctx, end := startTraceTask(ctx, QueryTypeExec)
+		qtype := tc.queryType(QueryTypeExec, query)
+		ctx = tc.sampleOperation(ctx, query)
+		mode := tc.propagationMode(qtype)
+		cquery, opts := tc.injectComments(ctx, query, mode)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		r, err := execContext.ExecContext(ctx, cquery, args)
-		tc.tryTrace(ctx, QueryTypeExec, query, start, err, append(withDBMTraceInjectedTag(tc.cfg.dbmPropagationMode), tracer.WithSpanID(spanID))...)
+		err = tc.tryTrace(ctx, qtype, query, start, err, append(opts, tc.paramHashTag(args)...)...)
 		return r, err
 	}
 	if execer, ok := tc.Conn.(driver.Execer); ok {
@@ -148,11 +201,14 @@ func (tc *TracedConn) ExecContext(ctx context.Context, query string, args []driv
 			return nil, ctx.Err()
 		default:
 		}
-		cquery, spanID := tc.injectComments(ctx, query, tc.cfg.dbmPropagationMode)
-		ctx, end := startTraceTask(ctx, QueryTypeExec)
+		qtype := tc.queryType(QueryTypeExec, query)
+		ctx = tc.sampleOperation(ctx, query)
+		mode := tc.propagationMode(qtype)
+		cquery, opts := tc.injectComments(ctx, query, mode)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		r, err = execer.Exec(cquery, dargs)
-		tc.tryTrace(ctx, QueryTypeExec, query, start, err, append(withDBMTraceInjectedTag(tc.cfg.dbmPropagationMode), tracer.WithSpanID(spanID))...)
+		err = tc.tryTrace(ctx, qtype, query, start, err, append(opts, tc.paramHashTag(args)...)...)
 		return r, err
 	}
 	return nil, driver.ErrSkip
@@ -166,7 +222,7 @@ func (tc *TracedConn) Ping(ctx context.Context) (err error) {
 		defer end()
 		err = pinger.Ping(ctx)
 	}
-	tc.tryTrace(ctx, QueryTypePing, "", start, err)
+	err = tc.tryTrace(ctx, QueryTypePing, "", start, err)
 	return err
 }
 
@@ -175,11 +231,14 @@ func (tc *TracedConn) Ping(ctx context.Context) (err error) {
 func (tc *TracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
 	start := time.Now()
 	if queryerContext, ok := tc.Conn.(driver.QueryerContext); ok {
-		cquery, spanID := tc.injectComments(ctx, query, tc.cfg.dbmPropagationMode)
-		ctx, end := startTraceTask(ctx, QueryTypeQuery)
+		qtype := tc.queryType(QueryTypeQuery, query)
+		ctx = tc.sampleOperation(ctx, query)
+		mode := tc.propagationMode(qtype)
+		cquery, opts := tc.injectComments(ctx, query, mode)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		rows, err := queryerContext.QueryContext(ctx, cquery, args)
-		tc.tryTrace(ctx, QueryTypeQuery, query, start, err, append(withDBMTraceInjectedTag(tc.cfg.dbmPropagationMode), tracer.WithSpanID(spanID))...)
+		err = tc.tryTrace(ctx, qtype, query, start, err, append(append(opts, tc.resultColumnTags(rows, err)...), tc.paramHashTag(args)...)...)
 		return rows, err
 	}
 	if queryer, ok := tc.Conn.(driver.Queryer); ok {
@@ -192,11 +251,14 @@ func (tc *TracedConn) QueryContext(ctx context.Context, query string, args []dri
 			return nil, ctx.Err()
 		default:
 		}
-		cquery, spanID := tc.injectComments(ctx, query, tc.cfg.dbmPropagationMode)
-		ctx, end := startTraceTask(ctx, QueryTypeQuery)
+		qtype := tc.queryType(QueryTypeQuery, query)
+		ctx = tc.sampleOperation(ctx, query)
+		mode := tc.propagationMode(qtype)
+		cquery, opts := tc.injectComments(ctx, query, mode)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		rows, err = queryer.Query(cquery, dargs)
-		tc.tryTrace(ctx, QueryTypeQuery, query, start, err, append(withDBMTraceInjectedTag(tc.cfg.dbmPropagationMode), tracer.WithSpanID(spanID))...)
+		err = tc.tryTrace(ctx, qtype, query, start, err, append(append(opts, tc.resultColumnTags(rows, err)...), tc.paramHashTag(args)...)...)
 		return rows, err
 	}
 	return nil, driver.ErrSkip
@@ -228,98 +290,549 @@ type traceParams struct {
 	cfg        *config
 	driverName string
 	meta       map[string]string
+	// inTx reports whether a transaction is in progress on the connection.
+	inTx bool
 }
 
 type contextKey int
 
-const spanTagsKey contextKey = 0 // map[string]string
+const (
+	spanTagsKey    contextKey = iota // map[string]string
+	queryNameKey                     // string
+	serviceNameKey                   // string
+	linkedSpanKey                    // ddtrace.SpanContext
+	operationKey                     // string
+	spanMetricsKey                   // map[string]float64
+	fanoutKey                        // *Fanout
+	dbmTagsKey                       // map[string]string
+	forceKeepKey                     // bool
+	sampledOutKey                    // bool
+)
 
 // WithSpanTags creates a new context containing the given set of tags. They will be added
-// to any query created with the returned context.
+// to any query created with the returned context. Tags set by previous calls on ctx's ancestors
+// are kept, and overridden by the given tags with the same keys.
 func WithSpanTags(ctx context.Context, tags map[string]string) context.Context {
+	if parent, ok := ctx.Value(spanTagsKey).(map[string]string); ok && len(parent) > 0 {
+		merged := make(map[string]string, len(parent)+len(tags))
+		for k, v := range parent {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			merged[k] = v
+		}
+		tags = merged
+	}
 	return context.WithValue(ctx, spanTagsKey, tags)
 }
 
+// WithSpanMetrics creates a new context containing the given set of numeric tags, which are set on
+// spans as metrics and can be aggregated, unlike the string tags set using WithSpanTags. They will
+// be added to any query created with the returned context, along with the tags set using WithSpanTags.
+// Metrics set by previous calls on ctx's ancestors are kept, and overridden by the given metrics
+// with the same keys.
+func WithSpanMetrics(ctx context.Context, metrics map[string]float64) context.Context {
+	if parent, ok := ctx.Value(spanMetricsKey).(map[string]float64); ok && len(parent) > 0 {
+		merged := make(map[string]float64, len(parent)+len(metrics))
+		for k, v := range parent {
+			merged[k] = v
+		}
+		for k, v := range metrics {
+			merged[k] = v
+		}
+		metrics = merged
+	}
+	return context.WithValue(ctx, spanMetricsKey, metrics)
+}
+
+// WithDBMTags creates a new context containing the given tags, e.g. a feature flag or an experiment
+// id, which are added to the SQL comments injected in the queries run with it when DBM propagation is
+// enabled, for correlation in Database Monitoring. Tags are merged with those of the parent context,
+// if any. Tags whose key holds other characters than letters, digits, '_', '.' and '-', which are
+// longer than 64 bytes or whose value is longer than 256 bytes or isn't valid UTF-8 are dropped, as
+// are the keys reserved for DBM: traceparent and those starting with dd. At most 8 tags are injected,
+// the first ones in the order of their keys.
+func WithDBMTags(ctx context.Context, tags map[string]string) context.Context {
+	if parent, ok := ctx.Value(dbmTagsKey).(map[string]string); ok && len(parent) > 0 {
+		merged := make(map[string]string, len(parent)+len(tags))
+		for k, v := range parent {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			merged[k] = v
+		}
+		tags = merged
+	}
+	return context.WithValue(ctx, dbmTagsKey, tags)
+}
+
+const (
+	maxDBMTags           = 8
+	maxDBMTagKeyLength   = 64
+	maxDBMTagValueLength = 256
+)
+
+// dbmTags returns the valid tags set in ctx by WithDBMTags, up to maxDBMTags.
+func dbmTags(ctx context.Context) map[string]string {
+	tags, ok := ctx.Value(dbmTagsKey).(map[string]string)
+	if !ok || len(tags) == 0 {
+		return nil
+	}
+	valid := make(map[string]string, len(tags))
+	for _, k := range sortedKeys(tags) {
+		v := tags[k]
+		if !validDBMTag(k, v) {
+			log.Debug("contrib/database/sql: dropping invalid DBM tag %q", k)
+			continue
+		}
+		if len(valid) == maxDBMTags {
+			log.Debug("contrib/database/sql: dropping DBM tag %q, at most %d tags are injected", k, maxDBMTags)
+			continue
+		}
+		valid[k] = v
+	}
+	return valid
+}
+
+// validDBMTag reports whether the given tag can be injected in SQL comments.
+func validDBMTag(k, v string) bool {
+	if k == "" || len(k) > maxDBMTagKeyLength || len(v) > maxDBMTagValueLength || !utf8.ValidString(v) {
+		return false
+	}
+	if strings.HasPrefix(k, "dd") || k == "traceparent" {
+		// reserved for DBM
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		switch c := k[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithQueryName creates a new context containing the given query name, e.g. the name of the
+// file the query was loaded from. It is used as the resource name of the spans of queries run
+// with the returned context instead of the query itself, and set as the db.query_name tag.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey, name)
+}
+
+// WithOperation creates a new context containing the name of the business operation the queries
+// run with it are part of, e.g. "checkout" or "user.signup". It is set as the db.app_operation tag,
+// and doesn't change the span operation name nor the resource name.
+func WithOperation(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationKey, name)
+}
+
+// WithForceKeep creates a new context marking the traces of the queries run with it to be kept, e.g.
+// for a request flagged for debugging. It takes precedence over WithSamplingDecider,
+// WithOperationSampleRates and WithMinDuration, but not over WithIgnoreQueryTypes or WithChildSpansOnly.
+func WithForceKeep(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKeepKey, true)
+}
+
+// propagationMode returns the DBM propagation mode to use for the next query of the given type, taking
+// into account the configured injection rate.
+func (tc *TracedConn) propagationMode(qtype QueryType) tracer.DBMPropagationMode {
+	if tc.cfg.dbmInjectionRate < 1 && rand.Float64() >= tc.cfg.dbmInjectionRate {
+		return tracer.DBMPropagationModeDisabled
+	}
+	if mode, ok := tc.cfg.dbmQueryTypeModes[qtype]; ok {
+		return mode
+	}
+	return tc.cfg.dbmPropagationMode
+}
+
+// WithServiceOverride creates a new context containing the given service name. It is used instead of
+// the configured service name (see WithServiceName and WithInstanceName) for the spans and DBM comments
+// of the queries run with the returned context, e.g. when a shared database serves requests attributed
+// to different logical services. It also takes precedence over a service name set using WithSpanOptions.
+func WithServiceOverride(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, serviceNameKey, service)
+}
+
+// service returns the service name to use for the spans and DBM comments of the given query, taking
+// into account the service set in ctx using WithServiceOverride and the suffixes set using
+// WithReadWriteServiceSuffixes.
+func (tp *traceParams) service(ctx context.Context, query string) string {
+	if name, ok := ctx.Value(serviceNameKey).(string); ok && name != "" {
+		return name
+	}
+	if tp.cfg.readServiceSuffix == "" && tp.cfg.writeServiceSuffix == "" {
+		return tp.cfg.service()
+	}
+	switch queryVerb(query) {
+	case "SELECT":
+		return tp.cfg.service() + tp.cfg.readServiceSuffix
+	case "INSERT", "UPDATE", "DELETE", "MERGE":
+		return tp.cfg.service() + tp.cfg.writeServiceSuffix
+	}
+	return tp.cfg.service()
+}
+
+// WithLinkedSpanContext creates a new context containing the given span context, e.g. the one of the
+// request which triggered asynchronous database work. When the returned context has no active span,
+// spans of queries run with it are created as children of the linked span context. Otherwise the
+// active span remains the parent, and the IDs of the linked span context are set as tags.
+func WithLinkedSpanContext(ctx context.Context, spanCtx ddtrace.SpanContext) context.Context {
+	return context.WithValue(ctx, linkedSpanKey, spanCtx)
+}
+
+// parentSpanContext returns the context of the span which the spans of the queries run with ctx
+// are children of, if any.
+func parentSpanContext(ctx context.Context) (ddtrace.SpanContext, bool) {
+	if span, ok := tracer.SpanFromContext(ctx); ok {
+		return span.Context(), true
+	}
+	if spanCtx, ok := ctx.Value(linkedSpanKey).(ddtrace.SpanContext); ok && spanCtx != nil {
+		return spanCtx, true
+	}
+	return nil, false
+}
+
+// sampleOperation decides whether the span of the given query is dropped by WithOperationSampleRates
+// before it is run, so that dropped queries don't get a dangling trace context injected, and returns
+// a context holding the decision.
+func (tp *traceParams) sampleOperation(ctx context.Context, query string) context.Context {
+	if query == "" || tp.cfg.operationSampleRates == nil {
+		return ctx
+	}
+	if forceKeep, _ := ctx.Value(forceKeepKey).(bool); forceKeep {
+		return ctx
+	}
+	return context.WithValue(ctx, sampledOutKey, rand.Float64() >= tp.cfg.sampleRate(query))
+}
+
+// sampledOut reports whether the span of the given query is dropped by WithOperationSampleRates,
+// using the decision made by sampleOperation if any.
+func (tp *traceParams) sampledOut(ctx context.Context, query string) bool {
+	if query == "" || tp.cfg.operationSampleRates == nil {
+		return false
+	}
+	if out, ok := ctx.Value(sampledOutKey).(bool); ok {
+		return out
+	}
+	return rand.Float64() >= tp.cfg.sampleRate(query)
+}
+
 // injectComments returns the query with SQL comments injected according to the comment injection mode along
-// with a span ID injected into SQL comments. The returned span ID should be used when the SQL span is created
-// following the traced database call.
-func (tc *TracedConn) injectComments(ctx context.Context, query string, mode tracer.DBMPropagationMode) (cquery string, spanID uint64) {
+// with the options of the SQL span, which should be used when it is created following the traced database call.
+// They include the span ID injected into SQL comments. If the injection fails in full mode, it is attempted
+// again in service mode, and the span is tagged with _dd.dbm_injection_error.
+// When ctx holds no span, full mode is handled as configured by WithDBMNoSpanBehavior.
+func (tc *TracedConn) injectComments(ctx context.Context, query string, mode tracer.DBMPropagationMode) (cquery string, spanOpts []tracer.StartSpanOption) {
 	// The sql span only gets created after the call to the database because we need to be able to skip spans
 	// when a driver returns driver.ErrSkip. In order to work with those constraints, a new span id is generated and
 	// used during SQL comment injection and returned for the sql span to be used later when/if the span
 	// gets created.
-	var spanCtx ddtrace.SpanContext
-	if span, ok := tracer.SpanFromContext(ctx); ok {
-		spanCtx = span.Context()
+	spanCtx, _ := parentSpanContext(ctx)
+	if out, _ := ctx.Value(sampledOutKey).(bool); out && mode == tracer.DBMPropagationModeFull {
+		// the span of the query is dropped, its id would be dangling
+		mode = tracer.DBMPropagationModeService
+	}
+	orphan := false
+	if mode == tracer.DBMPropagationModeFull && spanCtx == nil {
+		switch tc.cfg.dbmNoSpanBehavior {
+		case DBMNoSpanSkip:
+			mode = tracer.DBMPropagationModeService
+		case DBMNoSpanOrphan:
+			orphan = true
+		}
+	}
+	carrier := tracer.SQLCommentCarrier{Query: query, Mode: mode, DBServiceName: tc.service(ctx, query), Tags: dbmTags(ctx)}
+	parentCorrelation := tc.cfg.dbmParentCorrelation && spanCtx != nil
+	if parentCorrelation {
+		carrier.SpanID = spanCtx.SpanID()
 	}
-	carrier := tracer.SQLCommentCarrier{Query: query, Mode: mode, DBServiceName: tc.cfg.serviceName}
-	if err := carrier.Inject(spanCtx); err != nil {
-		// this should never happen
+	if err := injectQueryComments(&carrier, spanCtx); err != nil {
 		log.Warn("contrib/database/sql: failed to inject query comments: %v", err)
+		spanOpts = append(spanOpts, tracer.Tag(keyDBMInjectionError, true))
+		carrier.Query = query
+		if mode == tracer.DBMPropagationModeFull {
+			// service mode doesn't propagate the trace context, which leaves less room for failures
+			mode = tracer.DBMPropagationModeService
+			carrier.Mode = mode
+			if err := injectQueryComments(&carrier, spanCtx); err != nil {
+				carrier.Query = query
+			}
+		}
+	}
+	spanID := carrier.SpanID
+	if parentCorrelation {
+		// the sql span gets its own span id, the parent's one being in the comment
+		spanID = 0
 	}
-	return carrier.Query, carrier.SpanID
+	if orphan && mode == tracer.DBMPropagationModeFull {
+		spanID = 0
+		spanOpts = append(spanOpts, tracer.Tag(keyDBMOrphan, true))
+	}
+	spanOpts = append(spanOpts, tc.withDBMTraceInjectedTag(mode, spanCtx != nil, carrier.Sampled)...)
+	return carrier.Query, append(spanOpts, tracer.WithSpanID(spanID))
 }
 
-func withDBMTraceInjectedTag(mode tracer.DBMPropagationMode) []tracer.StartSpanOption {
-	if mode == tracer.DBMPropagationModeFull {
-		return []tracer.StartSpanOption{tracer.Tag(keyDBMTraceInjected, true)}
+// injectQueryComments injects the given span context in the carrier. It is replaced in tests to
+// simulate failures.
+var injectQueryComments = (*tracer.SQLCommentCarrier).Inject
+
+// withDBMTraceInjectedTag returns the span options marking the span of a query which got the trace
+// context injected in full mode. If the query has a parent span which isn't sampled, the injected
+// context may be dangling: the trace is either force-kept, as configured by WithDBMForceKeep, or the
+// span is tagged with db.dbm.dangling_risk. The sampling decision of root spans is left to the sampler.
+func (tc *TracedConn) withDBMTraceInjectedTag(mode tracer.DBMPropagationMode, parent, sampled bool) []tracer.StartSpanOption {
+	if mode != tracer.DBMPropagationModeFull {
+		return nil
 	}
-	return nil
+	opts := []tracer.StartSpanOption{tracer.Tag(keyDBMTraceInjected, true)}
+	if !parent || sampled {
+		return opts
+	}
+	if tc.cfg.dbmForceKeep {
+		return append(opts, tracer.Tag(ext.ManualKeep, true))
+	}
+	return append(opts, tracer.Tag(keyDBMDanglingRisk, true))
+}
+
+// resultColumnTags returns the span options tagging the shape of the given rows, as configured
+// by WithResultColumnTags. No tags are returned if the query failed with err, as drivers may then
+// return typed nil rows.
+func (tp *traceParams) resultColumnTags(rows driver.Rows, err error) []tracer.StartSpanOption {
+	if !tp.cfg.resultColumns || err != nil || rows == nil {
+		return nil
+	}
+	cols := rows.Columns()
+	opts := []tracer.StartSpanOption{tracer.Tag(keyResultColumnCount, len(cols))}
+	if !tp.cfg.resultColumnTypes {
+		return opts
+	}
+	if r, ok := rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		types := make([]string, len(cols))
+		for i := range cols {
+			types[i] = r.ColumnTypeDatabaseTypeName(i)
+		}
+		opts = append(opts, tracer.Tag(keyResultColumnTypes, strings.Join(types, ",")))
+	}
+	return opts
+}
+
+// queryType returns the query type of the given query, run as qtype: QueryTypeSessionConfig for
+// session configuration statements when enabled using WithSessionConfigQueryType, qtype otherwise.
+func (tp *traceParams) queryType(qtype QueryType, query string) QueryType {
+	if tp.cfg.sessionConfigQueryType && isSessionConfig(query) {
+		return QueryTypeSessionConfig
+	}
+	return qtype
+}
+
+// paramHashTag returns the span options tagging the hash of the given query arguments, as
+// configured by WithParamHashTag.
+func (tp *traceParams) paramHashTag(args []driver.NamedValue) []tracer.StartSpanOption {
+	if !tp.cfg.paramHashTag || len(args) == 0 {
+		return nil
+	}
+	h := fnv.New64a()
+	for _, arg := range args {
+		v := arg.Value
+		if t, ok := v.(time.Time); ok {
+			// the default format holds the monotonic clock reading
+			v = t.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%d:%s:%T:%v;", arg.Ordinal, arg.Name, v, v)
+	}
+	return []tracer.StartSpanOption{tracer.Tag(keyParamsHash, strconv.FormatUint(h.Sum64(), 16))}
+}
+
+// TracedError is returned by traced operations which failed when WithErrorDecoration is used. It
+// wraps the error of the operation along with the IDs of its span.
+type TracedError struct {
+	// Err is the error of the operation.
+	Err error
+	// TraceID is the ID of the trace of the operation's span.
+	TraceID uint64
+	// SpanID is the ID of the operation's span.
+	SpanID uint64
+}
+
+// Error implements error.
+func (e *TracedError) Error() string {
+	return fmt.Sprintf("%v (dd.trace_id=%d dd.span_id=%d)", e.Err, e.TraceID, e.SpanID)
+}
+
+// Unwrap returns the error of the operation.
+func (e *TracedError) Unwrap() error {
+	return e.Err
 }
 
 // tryTrace will create a span using the given arguments, but will act as a no-op when err is driver.ErrSkip.
-func (tp *traceParams) tryTrace(ctx context.Context, qtype QueryType, query string, startTime time.Time, err error, spanOpts ...ddtrace.StartSpanOption) {
+// It returns the error to return to the caller, which is err decorated with the span's IDs when a span is
+// created and WithErrorDecoration is used.
+func (tp *traceParams) tryTrace(ctx context.Context, qtype QueryType, query string, startTime time.Time, err error, spanOpts ...ddtrace.StartSpanOption) error {
 	if err == driver.ErrSkip {
 		// Not a user error: driver is telling sql package that an
 		// optional interface method is not implemented. There is
 		// nothing to trace here.
 		// See: https://github.com/DataDog/dd-trace-go/issues/270
-		return
+		return err
 	}
 	if tp.cfg.ignoreQueryTypes != nil {
 		if _, ok := tp.cfg.ignoreQueryTypes[qtype]; ok {
-			return
+			return err
+		}
+	}
+	if _, exists := parentSpanContext(ctx); tp.cfg.childSpansOnly && !exists {
+		if _, ok := tp.cfg.rootQueryTypes[qtype]; !ok {
+			return err
 		}
 	}
-	if _, exists := tracer.SpanFromContext(ctx); tp.cfg.childSpansOnly && !exists {
-		return
+	forceKeep, _ := ctx.Value(forceKeepKey).(bool)
+	if !forceKeep && tp.cfg.minDuration > 0 && time.Since(startTime) < tp.cfg.minDuration {
+		return err
+	}
+	var (
+		priority int
+		decided  bool
+	)
+	if forceKeep {
+		priority, decided = ext.PriorityUserKeep, true
+	} else if tp.cfg.samplingDecider != nil {
+		priority, decided = tp.cfg.samplingDecider(ctx, qtype, query)
+	}
+	if !decided && tp.sampledOut(ctx, query) {
+		return err
 	}
-	dbSystem, _ := normalizeDBSystem(tp.driverName)
 	opts := append(spanOpts,
-		tracer.ServiceName(tp.cfg.serviceName),
-		tracer.SpanType(ext.SpanTypeSQL),
+		tracer.ServiceName(tp.service(ctx, query)),
 		tracer.StartTime(startTime),
-		tracer.Tag(ext.Component, componentName),
-		tracer.Tag(ext.SpanKind, ext.SpanKindClient),
-		tracer.Tag(ext.DBSystem, dbSystem),
 	)
-	if tp.cfg.tags != nil {
-		for key, tag := range tp.cfg.tags {
-			opts = append(opts, tracer.Tag(key, tag))
+	opts = append(opts, tp.cfg.startOpts...)
+	if name, ok := ctx.Value(serviceNameKey).(string); ok && name != "" {
+		// applied last, so that it also overrides a service name set using WithSpanOptions
+		opts = append(opts, tracer.ServiceName(name))
+	}
+	if linked, ok := ctx.Value(linkedSpanKey).(ddtrace.SpanContext); ok && linked != nil {
+		if _, ok := tracer.SpanFromContext(ctx); ok {
+			opts = append(opts,
+				tracer.Tag(keyLinkedTraceID, strconv.FormatUint(linked.TraceID(), 10)),
+				tracer.Tag(keyLinkedSpanID, strconv.FormatUint(linked.SpanID(), 10)),
+			)
+		} else {
+			opts = append(opts, tracer.ChildOf(linked))
 		}
 	}
-	if !math.IsNaN(tp.cfg.analyticsRate) {
-		opts = append(opts, tracer.Tag(ext.EventSampleRate, tp.cfg.analyticsRate))
+	if len(tp.cfg.suppressTags) > 0 {
+		// applied last, so that suppressed tags passed as span options are removed as well
+		opts = append(opts, func(cfg *ddtrace.StartSpanConfig) {
+			for k := range tp.cfg.suppressTags {
+				delete(cfg.Tags, k)
+			}
+		})
+	}
+	name := tp.cfg.operationName(qtype)
+	span, _ := tracer.StartSpanFromContext(ctx, name, opts...)
+	if tp.cfg.spanRecorder != nil {
+		span = tp.cfg.spanRecorder.record(span, name, opts)
 	}
-	span, _ := tracer.StartSpanFromContext(ctx, tp.cfg.spanName, opts...)
 	resource := string(qtype)
 	if query != "" {
 		resource = query
+		if tp.cfg.obfuscator != nil {
+			resource = tp.cfg.obfuscator(query)
+		}
+		if tp.cfg.whitespaceNormalization {
+			resource = strings.Join(strings.Fields(resource), " ")
+		}
+		if name, ok := ctx.Value(queryNameKey).(string); ok && name != "" {
+			resource = name
+			tp.setTag(span, keyQueryName, name)
+		}
+		if tp.cfg.tableTag {
+			if table, ok := queryTable(query); ok {
+				tp.setTag(span, keySQLTable, table)
+			}
+		}
+		if tp.cfg.fullTableMutationTag && isFullTableMutation(query) {
+			tp.setTag(span, keyFullTableMutation, true)
+		}
+		if tp.cfg.resourcePrefix {
+			resource = strings.ToLower(string(qtype)) + ": " + resource
+		}
+	}
+	tp.setTag(span, "sql.query_type", string(qtype))
+	tp.setTag(span, keyInTransaction, tp.inTx)
+	if name, ok := ctx.Value(operationKey).(string); ok && name != "" {
+		tp.setTag(span, keyOperation, name)
 	}
-	span.SetTag("sql.query_type", string(qtype))
 	span.SetTag(ext.ResourceName, resource)
+	if decided {
+		span.SetTag(ext.SamplingPriority, priority)
+	}
 	for k, v := range tp.meta {
-		span.SetTag(k, v)
+		tp.setTag(span, k, v)
 	}
+	set := len(tp.cfg.tags)
 	if meta, ok := ctx.Value(spanTagsKey).(map[string]string); ok {
-		for k, v := range meta {
-			span.SetTag(k, v)
-		}
+		set = setUserTags(tp, span, meta, set)
 	}
-	if err != nil && (tp.cfg.errCheck == nil || tp.cfg.errCheck(err)) {
-		span.SetTag(ext.Error, err)
+	if metrics, ok := ctx.Value(spanMetricsKey).(map[string]float64); ok {
+		setUserTags(tp, span, metrics, set)
+	}
+	if err != nil {
+		if code, ok := sqlState(err); ok {
+			switch code {
+			case sqlStateSerializationFailure:
+				tp.setTag(span, keySerializationFailure, true)
+			case sqlStateQueryCanceled:
+				if ctx.Err() == nil && strings.Contains(err.Error(), statementTimeoutMessage) {
+					tp.setTag(span, keyStatementTimeout, true)
+				}
+			}
+		}
+		if tp.cfg.errCheck == nil || tp.cfg.errCheck(err) {
+			span.SetTag(ext.Error, err)
+		}
 	}
 	span.Finish()
+	if f, ok := ctx.Value(fanoutKey).(*Fanout); ok && query != "" && qtype != QueryTypeCacheHit {
+		f.add(time.Since(startTime))
+	}
+	if err != nil && tp.cfg.errorDecoration {
+		return &TracedError{Err: err, TraceID: span.Context().TraceID(), SpanID: span.Context().SpanID()}
+	}
+	return err
+}
+
+// setUserTags sets the given user tags on span, within the limit set by WithMaxTags, given the number of
+// user tags already set. It returns the number of user tags set so far, including the dropped ones.
+func setUserTags[V any](tp *traceParams, span ddtrace.Span, tags map[string]V, set int) int {
+	if tp.cfg.maxTags <= 0 {
+		for k, v := range tags {
+			tp.setTag(span, k, v)
+		}
+		return set + len(tags)
+	}
+	for _, k := range tp.cfg.limitTags(sortedKeys(tags), set) {
+		tp.setTag(span, k, tags[k])
+	}
+	return set + len(tags)
+}
+
+// setTag sets the given tag on span, unless it was suppressed using WithSuppressTags.
+func (tp *traceParams) setTag(span ddtrace.Span, key string, value interface{}) {
+	if !tp.cfg.suppressed(key) {
+		span.SetTag(key, value)
+	}
+}
+
+// sqlState returns the SQLSTATE code of the given error, if the driver exposes it through
+// a SQLState method (e.g. *pgconn.PgError).
+func sqlState(err error) (string, bool) {
+	var e interface{ SQLState() string }
+	if errors.As(err, &e) {
+		return e.SQLState(), true
+	}
+	return "", false
 }
 
 func normalizeDBSystem(driverName string) (string, bool) {
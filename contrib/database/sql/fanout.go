@@ -0,0 +1,69 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	fanoutSpanName      = "db.fanout"
+	keyFanoutQueries    = "db.fanout.queries"
+	keyFanoutDurationNs = "db.fanout.duration_ns"
+)
+
+// Fanout groups the spans of queries run concurrently under a single db.fanout span, giving a
+// cleaner shape to traces of parallel database access. See StartFanout.
+type Fanout struct {
+	span     ddtrace.Span
+	cfg      *config
+	queries  int64
+	duration int64 // nanoseconds
+}
+
+// StartFanout starts a db.fanout span as a child of the span in ctx, if any, and returns it along
+// with a context to run the concurrent queries with, e.g. in the goroutines of an errgroup.Group.
+// The spans of the queries are children of the db.fanout span, which is finished by Fanout.Finish
+// once all of them are done. Of the given options, only WithSuppressTags applies to the db.fanout
+// span, which isn't tied to a database.
+func StartFanout(ctx context.Context, opts ...Option) (*Fanout, context.Context) {
+	cfg := new(config)
+	for _, fn := range opts {
+		fn(cfg)
+	}
+	spanOpts := []ddtrace.StartSpanOption{tracer.SpanType(ext.SpanTypeSQL)}
+	if !cfg.suppressed(ext.Component) {
+		spanOpts = append(spanOpts, tracer.Tag(ext.Component, componentName))
+	}
+	span, ctx := tracer.StartSpanFromContext(ctx, fanoutSpanName, spanOpts...)
+	f := &Fanout{span: span, cfg: cfg}
+	return f, context.WithValue(ctx, fanoutKey, f)
+}
+
+// add records a query which took d to run.
+func (f *Fanout) add(d time.Duration) {
+	atomic.AddInt64(&f.queries, 1)
+	atomic.AddInt64(&f.duration, int64(d))
+}
+
+// Finish finishes the db.fanout span, tagging it with the number of traced queries run with its
+// context as db.fanout.queries, and with their aggregate duration in nanoseconds as
+// db.fanout.duration_ns. It must be called after all the queries are done.
+func (f *Fanout) Finish() {
+	if !f.cfg.suppressed(keyFanoutQueries) {
+		f.span.SetTag(keyFanoutQueries, atomic.LoadInt64(&f.queries))
+	}
+	if !f.cfg.suppressed(keyFanoutDurationNs) {
+		f.span.SetTag(keyFanoutDurationNs, atomic.LoadInt64(&f.duration))
+	}
+	f.span.Finish()
+}
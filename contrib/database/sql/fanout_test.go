@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanout(t *testing.T) {
+	mt, db := openMockDB(t, &internal.MockDriver{})
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "request")
+	fanout, ctx := StartFanout(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.ExecContext(ctx, "SELECT 1")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	fanout.Finish()
+	root.Finish()
+
+	var fanoutSpan mocktracer.Span
+	for _, s := range mt.FinishedSpans() {
+		if s.OperationName() == fanoutSpanName {
+			fanoutSpan = s
+		}
+	}
+	require.NotNil(t, fanoutSpan)
+	assert.Equal(t, root.Context().SpanID(), fanoutSpan.ParentID())
+	assert.Equal(t, int64(3), fanoutSpan.Tag(keyFanoutQueries))
+	assert.Greater(t, fanoutSpan.Tag(keyFanoutDurationNs), int64(0))
+
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeExec)
+	require.Len(t, spans, 3)
+	for _, s := range spans {
+		assert.Equal(t, fanoutSpan.SpanID(), s.ParentID())
+	}
+
+	t.Run("suppress-tags", func(t *testing.T) {
+		mt.Reset()
+		fanout, _ := StartFanout(context.Background(), WithSuppressTags(keyFanoutQueries, keyFanoutDurationNs))
+		fanout.Finish()
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag(keyFanoutQueries))
+		assert.Nil(t, spans[0].Tag(keyFanoutDurationNs))
+		assert.Equal(t, componentName, spans[0].Tag(ext.Component))
+	})
+}
@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	keyHealthReachable   = "db.health.reachable"
+	keyHealthPingLatency = "db.health.ping_latency_ns"
+	keyPoolOpen          = "db.pool.open_connections"
+	keyPoolInUse         = "db.pool.in_use"
+	keyPoolIdle          = "db.pool.idle"
+)
+
+// HealthStatus reports the health of a database, see Health.
+type HealthStatus struct {
+	// Reachable reports whether the database answered the ping.
+	Reachable bool
+	// PingLatency is the time the ping took.
+	PingLatency time.Duration
+	// Stats holds the statistics of the connection pool.
+	Stats sql.DBStats
+}
+
+// Health pings db, opened using Open or OpenDB, and reports its status along with the statistics of
+// its connection pool. It emits a single span of the QueryTypeHealth type, which can be excluded
+// using WithIgnoreQueryTypes. If no connection can be obtained, the error is returned and reported
+// by the span of the failed connection.
+func Health(ctx context.Context, db *sql.DB) (HealthStatus, error) {
+	var status HealthStatus
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		status.Stats = db.Stats()
+		return status, err
+	}
+	var tp *traceParams
+	start := time.Now()
+	err = conn.Raw(func(dc interface{}) error {
+		if c, ok := dc.(*TracedConn); ok {
+			// ping the driver's connection, so that no Ping span is created
+			tp, dc = c.traceParams, c.Conn
+		}
+		if pinger, ok := dc.(driver.Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+	status.PingLatency = time.Since(start)
+	status.Reachable = err == nil
+	conn.Close()
+	status.Stats = db.Stats()
+	if tp != nil {
+		err = tp.tryTrace(ctx, QueryTypeHealth, "", start, err,
+			tracer.Tag(keyHealthReachable, status.Reachable),
+			tracer.Tag(keyHealthPingLatency, int64(status.PingLatency)),
+			tracer.Tag(keyPoolOpen, status.Stats.OpenConnections),
+			tracer.Tag(keyPoolInUse, status.Stats.InUse),
+			tracer.Tag(keyPoolIdle, status.Stats.Idle),
+		)
+	}
+	return status, err
+}
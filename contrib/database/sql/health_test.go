@@ -0,0 +1,48 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	mt, db := openMockDB(t, &internal.MockDriver{})
+
+	status, err := Health(context.Background(), db)
+	require.NoError(t, err)
+	assert.True(t, status.Reachable)
+	assert.Equal(t, 1, status.Stats.OpenConnections)
+	assert.Equal(t, 0, status.Stats.InUse)
+
+	assert.Len(t, spansOfType(mt.FinishedSpans(), QueryTypePing), 0)
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeHealth)
+	require.Len(t, spans, 1)
+	assert.Equal(t, "Health", spans[0].Tag("resource.name"))
+	assert.Equal(t, true, spans[0].Tag(keyHealthReachable))
+	assert.Equal(t, int64(status.PingLatency), spans[0].Tag(keyHealthPingLatency))
+	assert.Equal(t, 1, spans[0].Tag(keyPoolOpen))
+	assert.Equal(t, 0, spans[0].Tag(keyPoolInUse))
+	assert.Equal(t, 1, spans[0].Tag(keyPoolIdle))
+
+	t.Run("ignored", func(t *testing.T) {
+		mt.Reset()
+		db, err := Open("test", "dn", WithIgnoreQueryTypes(QueryTypeHealth))
+		require.NoError(t, err)
+		defer db.Close()
+
+		status, err := Health(context.Background(), db)
+		require.NoError(t, err)
+		assert.True(t, status.Reachable)
+		assert.Len(t, spansOfType(mt.FinishedSpans(), QueryTypeHealth), 0)
+	})
+}
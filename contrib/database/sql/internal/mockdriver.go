@@ -17,6 +17,8 @@ type MockDriver struct {
 	Executed []string
 	// Hook is an optional function to run during a DB operation
 	Hook func()
+	// Err is an optional error returned by query and exec operations
+	Err error
 }
 
 // Open implements the Conn interface
@@ -46,6 +48,9 @@ func (m *mockConn) QueryContext(_ context.Context, query string, _ []driver.Name
 	if m.driver.Hook != nil {
 		m.driver.Hook()
 	}
+	if m.driver.Err != nil {
+		return nil, m.driver.Err
+	}
 	return &rows{}, nil
 }
 
@@ -55,6 +60,9 @@ func (m *mockConn) ExecContext(_ context.Context, query string, _ []driver.Named
 	if m.driver.Hook != nil {
 		m.driver.Hook()
 	}
+	if m.driver.Err != nil {
+		return nil, m.driver.Err
+	}
 	return &mockResult{}, nil
 }
 
@@ -6,25 +6,112 @@
 package sql
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
 )
 
 type config struct {
-	serviceName        string
-	spanName           string
-	analyticsRate      float64
-	dsn                string
-	ignoreQueryTypes   map[QueryType]struct{}
-	childSpansOnly     bool
-	errCheck           func(err error) bool
-	tags               map[string]interface{}
-	dbmPropagationMode tracer.DBMPropagationMode
+	serviceName                  string
+	spanName                     string
+	analyticsRate                float64
+	dsn                          string
+	ignoreQueryTypes             map[QueryType]struct{}
+	childSpansOnly               bool
+	errCheck                     func(err error) bool
+	tags                         map[string]interface{}
+	dbmPropagationMode           tracer.DBMPropagationMode
+	minDuration                  time.Duration
+	peerTags                     map[string]string
+	spanOpts                     []ddtrace.StartSpanOption
+	dbmInjectionRate             float64
+	resultColumns                bool
+	resultColumnTypes            bool
+	instanceName                 string
+	instanceSuffix               bool
+	suppressTags                 map[string]struct{}
+	dbmParentCorrelation         bool
+	obfuscator                   func(query string) string
+	preparedStatementDBMDisabled bool
+	slowCommitThreshold          time.Duration
+	resourcePrefix               bool
+	tableTag                     bool
+	operationSampleRates         map[string]float64
+	dbmForceKeep                 bool
+	spanKind                     string
+	poolID                       string
+	poolIDEnabled                bool
+	rootQueryTypes               map[QueryType]struct{}
+	perTypeMetrics               bool
+	spanRecorder                 *SpanRecorder
+	maxTags                      int
+	whitespaceNormalization      bool
+	errorDecoration              bool
+	dbmQueryTypeModes            map[QueryType]tracer.DBMPropagationMode
+	readServiceSuffix            string
+	writeServiceSuffix           string
+	fullTableMutationTag         bool
+	dbmNoSpanBehavior            DBMNoSpanBehavior
+	paramHashTag                 bool
+	sessionConfigQueryType       bool
+	samplingDecider              func(ctx context.Context, qtype QueryType, query string) (priority int, ok bool)
+
+	// invalid holds the errors found while applying options.
+	invalid []*ConfigError
+	// startOpts holds the span options shared by all spans, computed by OpenDB.
+	startOpts []ddtrace.StartSpanOption
+	// maxTagsWarned is set to 1 once a warning was logged about tags dropped because of WithMaxTags.
+	maxTagsWarned uint32
+}
+
+// ConfigError is returned by Open when the given options result in an invalid configuration. The
+// following configurations are considered invalid:
+//   - a DBM injection rate or operation sample rate outside of the [0, 1] range,
+//   - a negative duration passed to WithMinDuration or WithSlowCommitThreshold,
+//   - an empty name passed to WithInstanceName along with suffixService,
+//   - an unknown behavior passed to WithDBMNoSpanBehavior.
+//
+// Register and OpenDB, which can't return errors, log them instead.
+type ConfigError struct {
+	// Option is the name of the invalid option.
+	Option string
+	// Reason describes why the option is invalid.
+	Reason string
+}
+
+// Error implements error.
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("sqltrace: invalid %s option: %s", e.Option, e.Reason)
+}
+
+// validate returns the first error found in the configuration, if any.
+func (c *config) validate() error {
+	if len(c.invalid) > 0 {
+		return c.invalid[0]
+	}
+	if c.minDuration < 0 {
+		return &ConfigError{Option: "WithMinDuration", Reason: "duration must not be negative"}
+	}
+	if c.slowCommitThreshold < 0 {
+		return &ConfigError{Option: "WithSlowCommitThreshold", Reason: "duration must not be negative"}
+	}
+	if c.instanceSuffix && c.instanceName == "" {
+		return &ConfigError{Option: "WithInstanceName", Reason: "name must not be empty when suffixing the service"}
+	}
+	return nil
 }
 
 // Option represents an option that can be passed to Register, Open or OpenDB.
@@ -36,31 +123,98 @@ type registerConfig = config
 type RegisterOption = Option
 
 func defaults(cfg *config, driverName string, rc *registerConfig) {
+	if rc != nil {
+		// use registered config as the default value for all options; maps and slices are
+		// cloned, as options such as WithCustomTag or WithPeerTags add to the existing ones.
+		*cfg = *rc
+		cfg.ignoreQueryTypes = copyMap(rc.ignoreQueryTypes)
+		cfg.tags = copyMap(rc.tags)
+		cfg.peerTags = copyMap(rc.peerTags)
+		cfg.spanOpts = append([]ddtrace.StartSpanOption(nil), rc.spanOpts...)
+		cfg.suppressTags = copyMap(rc.suppressTags)
+		cfg.operationSampleRates = copyMap(rc.operationSampleRates)
+		cfg.rootQueryTypes = copyMap(rc.rootQueryTypes)
+		cfg.dbmQueryTypeModes = copyMap(rc.dbmQueryTypeModes)
+		cfg.invalid = append([]*ConfigError(nil), rc.invalid...)
+		cfg.startOpts = nil
+		cfg.maxTagsWarned = 0
+	} else {
+		cfg.analyticsRate = math.NaN()
+		cfg.dbmInjectionRate = 1.0
+		cfg.spanKind = ext.SpanKindClient
+	}
 	// cfg.analyticsRate = globalconfig.AnalyticsRate()
 	if internal.BoolEnv("DD_TRACE_SQL_ANALYTICS_ENABLED", false) {
 		cfg.analyticsRate = 1.0
-	} else {
-		cfg.analyticsRate = math.NaN()
+	}
+	if v := os.Getenv("DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE"); v != "" {
+		if rate, err := strconv.ParseFloat(v, 64); err == nil && rate >= 0.0 && rate <= 1.0 {
+			cfg.analyticsRate = rate
+		} else {
+			log.Warn("contrib/database/sql: ignoring invalid DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE value %q", v)
+		}
 	}
 	mode := os.Getenv("DD_DBM_PROPAGATION_MODE")
 	if mode == "" {
 		mode = os.Getenv("DD_TRACE_SQL_COMMENT_INJECTION_MODE")
 	}
-	cfg.dbmPropagationMode = tracer.DBMPropagationMode(mode)
+	if mode != "" {
+		cfg.dbmPropagationMode = tracer.DBMPropagationMode(mode)
+	}
 	cfg.serviceName = getServiceName(driverName, rc)
 	cfg.spanName = getSpanName(driverName)
-	if rc != nil {
-		// use registered config as the default value for some options
-		if math.IsNaN(cfg.analyticsRate) {
-			cfg.analyticsRate = rc.analyticsRate
+}
+
+// service returns the service name used for spans and DBM comments.
+func (c *config) service() string {
+	if c.instanceSuffix && c.instanceName != "" {
+		return c.serviceName + "-" + c.instanceName
+	}
+	return c.serviceName
+}
+
+// staticSpanOptions returns the span options which are the same for all spans, so that they
+// don't need to be computed every time a span is started. Options set using WithSpanOptions come
+// last, allowing them to override the others.
+func (c *config) staticSpanOptions(driverName string) []ddtrace.StartSpanOption {
+	dbSystem, _ := normalizeDBSystem(driverName)
+	opts := []ddtrace.StartSpanOption{tracer.SpanType(ext.SpanTypeSQL)}
+	if c.perTypeMetrics {
+		opts = append(opts, tracer.Measured())
+	}
+	tag := func(key string, value interface{}) {
+		if !c.suppressed(key) {
+			opts = append(opts, tracer.Tag(key, value))
+		}
+	}
+	tag(ext.Component, componentName)
+	tag(ext.SpanKind, c.spanKind)
+	tag(ext.DBSystem, dbSystem)
+	if c.instanceName != "" {
+		tag(keyInstanceName, c.instanceName)
+	}
+	if c.poolID != "" {
+		tag(keyPoolID, c.poolID)
+	}
+	if c.maxTags > 0 {
+		for _, key := range c.limitTags(sortedKeys(c.tags), 0) {
+			tag(key, c.tags[key])
 		}
-		if cfg.dbmPropagationMode == tracer.DBMPropagationModeUndefined {
-			cfg.dbmPropagationMode = rc.dbmPropagationMode
+	} else {
+		for key, value := range c.tags {
+			tag(key, value)
 		}
-		cfg.errCheck = rc.errCheck
-		cfg.ignoreQueryTypes = rc.ignoreQueryTypes
-		cfg.childSpansOnly = rc.childSpansOnly
 	}
+	if !math.IsNaN(c.analyticsRate) {
+		tag(ext.EventSampleRate, c.analyticsRate)
+	}
+	return append(opts, c.spanOpts...)
+}
+
+// suppressed reports whether the tag with the given key should not be set on spans.
+func (c *config) suppressed(key string) bool {
+	_, ok := c.suppressTags[key]
+	return ok
 }
 
 func getServiceName(driverName string, rc *registerConfig) string {
@@ -108,12 +262,18 @@ func WithAnalytics(on bool) Option {
 
 // WithAnalyticsRate sets the sampling rate for Trace Analytics events
 // correlated to started spans.
+//
+// The rate can also be set using the DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE environment variable,
+// which takes precedence over DD_TRACE_SQL_ANALYTICS_ENABLED. Options passed to Open or OpenDB
+// take precedence over the environment, which itself takes precedence over options passed to
+// Register.
 func WithAnalyticsRate(rate float64) Option {
 	return func(cfg *config) {
 		if rate >= 0.0 && rate <= 1.0 {
 			cfg.analyticsRate = rate
 		} else {
 			cfg.analyticsRate = math.NaN()
+			log.Warn("contrib/database/sql: ignoring invalid analytics rate %v, rate must be between 0 and 1", rate)
 		}
 	}
 }
@@ -148,6 +308,21 @@ func WithChildSpansOnly() Option {
 	}
 }
 
+// WithRootSpanQueryTypes specifies the query types for which spans are created even when
+// there is no parent span in the Context, despite WithChildSpansOnly. Such spans are roots of
+// new traces, which keeps critical operations correlated with the trace context injected in
+// their SQL comments when DBM propagation is enabled.
+func WithRootSpanQueryTypes(qtypes ...QueryType) Option {
+	return func(cfg *config) {
+		if cfg.rootQueryTypes == nil {
+			cfg.rootQueryTypes = make(map[QueryType]struct{})
+		}
+		for _, qt := range qtypes {
+			cfg.rootQueryTypes[qt] = struct{}{}
+		}
+	}
+}
+
 // WithErrorCheck specifies a function fn which determines whether the passed
 // error should be marked as an error. The fn is called whenever a database/sql operation
 // finishes with an error
@@ -188,3 +363,403 @@ func WithDBMPropagation(mode tracer.DBMPropagationMode) Option {
 		cfg.dbmPropagationMode = mode
 	}
 }
+
+// WithQueryTypeDBMPropagation sets the DBM propagation mode used for queries of the given type instead
+// of the one set using WithDBMPropagation, e.g. to use full mode for QueryTypeExec but only service mode
+// for high-volume QueryTypeQuery. It can be called once per query type. As with WithDBMPropagation,
+// QueryTypePrepare only supports service mode, and full mode falls back to it.
+func WithQueryTypeDBMPropagation(qtype QueryType, mode tracer.DBMPropagationMode) Option {
+	return func(cfg *config) {
+		modes := make(map[QueryType]tracer.DBMPropagationMode, len(cfg.dbmQueryTypeModes)+1)
+		for qt, m := range cfg.dbmQueryTypeModes {
+			modes[qt] = m
+		}
+		modes[qtype] = mode
+		cfg.dbmQueryTypeModes = modes
+	}
+}
+
+// WithMinDuration causes spans to be created only for operations which took at least d
+// to complete. Since spans are created once the operation has finished, faster operations
+// are simply not traced. DBM propagation is unaffected: comments are still injected in
+// all queries, using the span ID that the span would have if it gets created.
+func WithMinDuration(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.minDuration = d
+	}
+}
+
+// WithPeerTags sets the given peer tags (e.g. peer.service) on all spans. These tags are used
+// by Datadog to render the database as a downstream of the traced service in the service map.
+// Regardless of this option, all spans are tagged with peer.hostname and db.instance, copied
+// from out.host and db.name when the DSN provides them. Unless set here, peer.service is only
+// computed by the tracer, from db.name or db.instance, when peer service defaults are enabled
+// (see DD_TRACE_PEER_SERVICE_DEFAULTS_ENABLED).
+func WithPeerTags(tags map[string]string) Option {
+	return func(cfg *config) {
+		if cfg.peerTags == nil {
+			cfg.peerTags = make(map[string]string)
+		}
+		for k, v := range tags {
+			cfg.peerTags[k] = v
+		}
+	}
+}
+
+// WithSpanOptions appends the given options to the ones used when starting every span.
+// They are applied after the options set by this package, so they can be used to override
+// them (e.g. the service name). Note that the resource name, the query type and the tags
+// taken from the DSN or the context are set once the span is started and can't be overridden.
+func WithSpanOptions(opts ...ddtrace.StartSpanOption) Option {
+	return func(cfg *config) {
+		cfg.spanOpts = append(cfg.spanOpts, opts...)
+	}
+}
+
+// WithDBMInjectionRate sets the rate at which SQL comments are injected in queries when DBM propagation
+// is enabled. Queries are traced regardless of the rate. It defaults to 1.0, meaning that all
+// queries get comments injected. Values outside of the [0, 1] range make Open return a *ConfigError.
+func WithDBMInjectionRate(rate float64) Option {
+	return func(cfg *config) {
+		if rate >= 0.0 && rate <= 1.0 {
+			cfg.dbmInjectionRate = rate
+		} else {
+			cfg.invalid = append(cfg.invalid, &ConfigError{Option: "WithDBMInjectionRate", Reason: "rate must be between 0 and 1"})
+		}
+	}
+}
+
+// WithResultColumnTags tags query spans with the number of columns returned by the query, which
+// helps catching unexpected schema changes. If withTypes is true, the database type names of
+// the columns are also tagged, provided that the driver reports them.
+func WithResultColumnTags(withTypes bool) Option {
+	return func(cfg *config) {
+		cfg.resultColumns = true
+		cfg.resultColumnTypes = withTypes
+	}
+}
+
+// WithInstanceName tags all spans with the given logical database name, which helps
+// telling apart the databases of a service talking to several of them. If suffixService
+// is true, the name is also appended to the service name, e.g. "postgres.db-orders".
+// The name isn't used to compute peer.service: use WithPeerTags to set it explicitly.
+func WithInstanceName(name string, suffixService bool) Option {
+	return func(cfg *config) {
+		cfg.instanceName = name
+		cfg.instanceSuffix = suffixService
+	}
+}
+
+// WithDBMParentCorrelation sets whether queries propagated using DBMPropagationModeFull
+// are correlated to the span found in the context, instead of the SQL span created for the query,
+// which is the default. Correlating to the parent span ensures that the span referenced by the
+// database exists even when the SQL span is not created (see WithChildSpansOnly, WithMinDuration
+// or WithIgnoreQueryTypes), at the cost of not being able to tell which SQL span matches which
+// query when a parent span runs several of them. When there is no span in the context, the SQL
+// span is used.
+func WithDBMParentCorrelation(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.dbmParentCorrelation = enabled
+	}
+}
+
+// WithSuppressTags prevents the tags with the given keys from being set on spans, including the ones
+// set by this package, e.g. to save on tag budgets. The service, resource, span type and error can't
+// be suppressed. When peer service defaults are enabled, the tracer needs span.kind and db.system to
+// compute peer.service from db.name or db.instance.
+func WithSuppressTags(keys ...string) Option {
+	return func(cfg *config) {
+		if cfg.suppressTags == nil {
+			cfg.suppressTags = make(map[string]struct{})
+		}
+		for _, k := range keys {
+			switch k {
+			case ext.ServiceName, ext.ResourceName, ext.SpanType, ext.Error:
+				continue
+			}
+			cfg.suppressTags[k] = struct{}{}
+		}
+	}
+}
+
+// WithObfuscator sets a function used to obfuscate queries before they are used as resource names,
+// e.g. to match the obfuscation applied to server side logs. The query sent to the database is left
+// untouched. Query names set using WithQueryName take precedence over the obfuscated query.
+func WithObfuscator(fn func(query string) string) Option {
+	return func(cfg *config) {
+		cfg.obfuscator = fn
+	}
+}
+
+// WithWhitespaceNormalization sets whether runs of whitespace in queries are collapsed into single
+// spaces, and leading and trailing whitespace trimmed, before queries are used as resource names. This
+// reduces the cardinality of resources for generated queries with inconsistent formatting, at a lower
+// cost than obfuscation. It is applied after the obfuscator set using WithObfuscator, if any. Note that
+// whitespace in string literals is collapsed as well. The query sent to the database is left untouched.
+func WithWhitespaceNormalization(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.whitespaceNormalization = enabled
+	}
+}
+
+// WithReadWriteServiceSuffixes appends the given suffixes to the service name of the spans and DBM
+// comments of reads (SELECT queries) and writes (INSERT, UPDATE, DELETE and MERGE queries), e.g.
+// "-read" and "-write", allowing to define separate service level objectives for them. Other
+// operations, such as Begin or Commit, keep the service name as is, as do queries run with a
+// context returned by WithServiceOverride.
+func WithReadWriteServiceSuffixes(read, write string) Option {
+	return func(cfg *config) {
+		cfg.readServiceSuffix = read
+		cfg.writeServiceSuffix = write
+	}
+}
+
+// WithFullTableMutationTag sets whether spans of UPDATE and DELETE queries without WHERE clause are
+// tagged with db.full_table_mutation, e.g. to alert on accidental mass mutations. Queries are only
+// tagged when this is certain, e.g. not when they use common table expressions or LIMIT.
+func WithFullTableMutationTag(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.fullTableMutationTag = enabled
+	}
+}
+
+// WithSessionConfigQueryType sets whether session configuration statements, i.e. SET, RESET and
+// SELECT set_config(...), are traced with the QueryTypeSessionConfig query type instead of
+// QueryTypeExec or QueryTypeQuery, so that they can be told apart from business queries, e.g.
+// ignored using WithIgnoreQueryTypes. They are detected by their first tokens.
+func WithSessionConfigQueryType(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.sessionConfigQueryType = enabled
+	}
+}
+
+// WithParamHashTag sets whether spans of queries run with arguments are tagged with db.params.hash,
+// a hash of the arguments which allows finding repeated executions of a query with identical
+// arguments without exposing their values. The hash isn't salted, so that it is stable across
+// processes: arguments from a small set of values, e.g. booleans, can be found out from it.
+func WithParamHashTag(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.paramHashTag = enabled
+	}
+}
+
+// WithErrorDecoration sets whether the errors of traced operations are wrapped in a *TracedError
+// holding the IDs of the span of the operation, allowing to find its trace from application logs.
+// The wrapped error can still be found using errors.Is and errors.As. Errors of operations which
+// aren't traced, e.g. because of WithIgnoreQueryTypes, are returned as is.
+func WithErrorDecoration(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.errorDecoration = enabled
+	}
+}
+
+// WithoutPreparedStatementDBM disables DBM propagation for prepared statements, which otherwise
+// get service level comments injected (see WithDBMPropagation). Prepare spans are still created.
+func WithoutPreparedStatementDBM(disabled bool) Option {
+	return func(cfg *config) {
+		cfg.preparedStatementDBMDisabled = disabled
+	}
+}
+
+// WithSlowCommitThreshold tags Commit spans with db.commit.slow, set to true when the commit took
+// at least the given duration. Since commit latency is often dominated by the time the database
+// takes to flush its write-ahead log, this helps finding storage bound transactions.
+func WithSlowCommitThreshold(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.slowCommitThreshold = d
+	}
+}
+
+// WithResourcePrefix sets whether the resource names of queries are prefixed with their query type,
+// e.g. "exec: UPDATE users SET name = ?", telling apart identical queries run in different ways.
+// It is disabled by default.
+func WithResourcePrefix(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.resourcePrefix = enabled
+	}
+}
+
+// WithTableTag sets whether spans are tagged with db.sql.table, the main table of the query: the
+// table following the first FROM, INTO or UPDATE keyword, which may be schema-qualified. Finding it
+// requires scanning the query, which is why it is disabled by default. This is done on a best-effort
+// basis, and the tag isn't set when the table can't be found with certainty.
+func WithTableTag(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.tableTag = enabled
+	}
+}
+
+// WithOperationSampleRates sets the rate at which spans of queries are kept by SQL verb, e.g.
+// {"SELECT": 0.1}, the "*" key applying to other verbs. Spans which aren't kept are not created, so
+// they are missing from the trace metrics (hits, errors and latency), and their queries only get the
+// service tags injected in full DBM propagation mode. Query types ignored using WithIgnoreQueryTypes
+// are never traced, and the analytics rate applies to the kept spans.
+func WithOperationSampleRates(rates map[string]float64) Option {
+	return func(cfg *config) {
+		cfg.operationSampleRates = make(map[string]float64, len(rates))
+		for verb, rate := range rates {
+			if rate < 0.0 || rate > 1.0 {
+				cfg.invalid = append(cfg.invalid, &ConfigError{Option: "WithOperationSampleRates", Reason: "rate must be between 0 and 1"})
+				continue
+			}
+			cfg.operationSampleRates[strings.ToUpper(verb)] = rate
+		}
+	}
+}
+
+// WithDBMForceKeep sets whether traces are kept when a query gets a trace context injected in full DBM
+// propagation mode while its parent span isn't sampled. Otherwise, the correlation found by Database
+// Monitoring could point to a dropped trace, and such spans are tagged with db.dbm.dangling_risk instead.
+func WithDBMForceKeep(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.dbmForceKeep = enabled
+	}
+}
+
+// DBMNoSpanBehavior is the behavior of full DBM propagation for queries run with a context holding
+// no span, see WithDBMNoSpanBehavior.
+type DBMNoSpanBehavior string
+
+const (
+	// DBMNoSpanRoot injects a newly generated span ID, which is used by the span of the query, making
+	// it the root span of a new trace. This is the default.
+	DBMNoSpanRoot DBMNoSpanBehavior = "root"
+	// DBMNoSpanSkip doesn't inject the trace context, falling back to service propagation mode.
+	DBMNoSpanSkip DBMNoSpanBehavior = "skip"
+	// DBMNoSpanOrphan injects a newly generated span ID, which isn't used by any span, and tags the
+	// span of the query with db.dbm.orphan.
+	DBMNoSpanOrphan DBMNoSpanBehavior = "orphan"
+)
+
+// WithDBMNoSpanBehavior sets the behavior of full DBM propagation for queries run with a context
+// holding no span. By default, the injected trace context is the one of the span of the query,
+// which becomes a root span. As such traces rarely bring more insight than the query samples of
+// Database Monitoring, DBMNoSpanSkip is recommended to only propagate the trace context of queries
+// being part of a request.
+func WithDBMNoSpanBehavior(b DBMNoSpanBehavior) Option {
+	return func(cfg *config) {
+		switch b {
+		case DBMNoSpanRoot, DBMNoSpanSkip, DBMNoSpanOrphan:
+			cfg.dbmNoSpanBehavior = b
+		default:
+			cfg.invalid = append(cfg.invalid, &ConfigError{Option: "WithDBMNoSpanBehavior", Reason: fmt.Sprintf("unknown behavior %q", b)})
+		}
+	}
+}
+
+// WithSamplingDecider sets a function deciding the sampling priority of the traces of operations,
+// e.g. ext.PriorityUserKeep, given their context, query type and query. When it returns true, the
+// priority is set on the span and WithOperationSampleRates doesn't apply. Operations filtered out by
+// WithIgnoreQueryTypes, WithChildSpansOnly or WithMinDuration are never passed to it.
+func WithSamplingDecider(fn func(ctx context.Context, qtype QueryType, query string) (priority int, ok bool)) Option {
+	return func(cfg *config) {
+		cfg.samplingDecider = fn
+	}
+}
+
+// WithSpanKind sets the span kind of all spans, e.g. ext.SpanKindServer when instrumenting a proxy
+// serving database clients. It defaults to ext.SpanKindClient.
+func WithSpanKind(kind string) Option {
+	return func(cfg *config) {
+		cfg.spanKind = kind
+	}
+}
+
+// WithPoolID tags all spans of the database handle returned by Open or OpenDB with db.pool.id, which
+// helps telling apart the spans of several handles opened by a service, e.g. one per shard. If id is
+// empty, a unique id made of the driver name and a sequence number is generated for each handle, and
+// remains the same for its lifetime.
+func WithPoolID(id string) Option {
+	return func(cfg *config) {
+		cfg.poolID = id
+		cfg.poolIDEnabled = true
+	}
+}
+
+// WithPerTypeMetrics sets whether trace metrics are computed separately for each query type. When
+// enabled, the query type is appended in lower case to the span operation name, e.g. postgres.query
+// becomes postgres.query.exec, postgres.query.commit, etc., and spans are measured. The resulting
+// latency, hits and errors metrics, such as trace.postgres.query.exec.duration, are then available
+// per query type and resource.
+func WithPerTypeMetrics(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.perTypeMetrics = enabled
+	}
+}
+
+// WithSpanRecorder records the spans created by this package in r, in addition to starting them
+// with the global tracer, allowing tests to check them without a tracer.
+func WithSpanRecorder(r *SpanRecorder) Option {
+	return func(cfg *config) {
+		cfg.spanRecorder = r
+	}
+}
+
+// WithMaxTags sets the maximum number of user tags set on each span, as a safety valve against
+// runaway tag cardinality. User tags are the ones set using WithCustomTag, WithSpanTags and
+// WithSpanMetrics, in this order, each of them in the lexical order of their keys: once n tags
+// were set, the following ones are dropped and a warning is logged. Other tags, including the
+// ones set by WithPeerTags and WithSpanOptions, are always set. A value of 0 or less, the default,
+// means no limit.
+func WithMaxTags(n int) Option {
+	return func(cfg *config) {
+		cfg.maxTags = n
+	}
+}
+
+// limitTags returns the given user tag keys truncated according to WithMaxTags, given the number
+// of user tags already set, and logs a warning the first time tags are dropped.
+func (c *config) limitTags(keys []string, set int) []string {
+	if c.maxTags <= 0 || set+len(keys) <= c.maxTags {
+		return keys
+	}
+	if atomic.CompareAndSwapUint32(&c.maxTagsWarned, 0, 1) {
+		log.Warn("contrib/database/sql: dropping user tags above the limit of %d set by WithMaxTags", c.maxTags)
+	}
+	if set >= c.maxTags {
+		return nil
+	}
+	return keys[:c.maxTags-set]
+}
+
+// sortedKeys returns the keys of the given tags in lexical order.
+func sortedKeys[V any](tags map[string]V) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyMap returns a copy of m, or nil if m is nil.
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// operationName returns the operation name of spans of the given query type.
+func (c *config) operationName(qtype QueryType) string {
+	if c.perTypeMetrics {
+		return c.spanName + "." + strings.ToLower(string(qtype))
+	}
+	return c.spanName
+}
+
+// sampleRate returns the rate at which spans of the given query are kept, as configured by
+// WithOperationSampleRates.
+func (c *config) sampleRate(query string) float64 {
+	if rate, ok := c.operationSampleRates[queryVerb(query)]; ok {
+		return rate
+	}
+	if rate, ok := c.operationSampleRates["*"]; ok {
+		return rate
+	}
+	return 1
+}
@@ -6,6 +6,7 @@
 package sql
 
 import (
+	"math"
 	"testing"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
@@ -42,4 +43,28 @@ func TestAnalyticsSettings(t *testing.T) {
 		WithAnalyticsRate(0.2)(cfg)
 		assert.Equal(t, 0.2, cfg.analyticsRate)
 	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("DD_TRACE_SQL_ANALYTICS_ENABLED", "true")
+		t.Setenv("DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE", "0.3")
+		cfg := new(registerConfig)
+		defaults(cfg, "", nil)
+		assert.Equal(t, 0.3, cfg.analyticsRate)
+	})
+
+	t.Run("env-invalid", func(t *testing.T) {
+		t.Setenv("DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE", "2")
+		cfg := new(registerConfig)
+		defaults(cfg, "", nil)
+		assert.True(t, math.IsNaN(cfg.analyticsRate))
+	})
+
+	t.Run("env-override", func(t *testing.T) {
+		t.Setenv("DD_TRACE_SQL_ANALYTICS_SAMPLE_RATE", "0.3")
+		cfg := new(registerConfig)
+		defaults(cfg, "", &registerConfig{analyticsRate: 0.1})
+		assert.Equal(t, 0.3, cfg.analyticsRate)
+		WithAnalyticsRate(0.2)(cfg)
+		assert.Equal(t, 0.2, cfg.analyticsRate)
+	})
 }
@@ -8,10 +8,14 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
@@ -32,6 +36,8 @@ func TestDBMPropagation(t *testing.T) {
 		callDB   func(ctx context.Context, db *sql.DB) error
 		prepared []string
 		executed []*regexp.Regexp
+		// parent reports whether the injected span ID is expected to be the parent's span ID
+		parent bool
 	}{
 		{
 			name: "prepare",
@@ -69,6 +75,15 @@ func TestDBMPropagation(t *testing.T) {
 			},
 			prepared: []string{"/*dddbs='test.db',dde='test-env',ddps='test-service',ddpv='1.0.0'*/ SELECT 1 from DUAL"},
 		},
+		{
+			name: "prepare-full-without-prepared-dbm",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeFull), WithoutPreparedStatementDBM(true)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.PrepareContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			prepared: []string{"SELECT 1 from DUAL"},
+		},
 		{
 			name: "query",
 			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeDisabled)},
@@ -141,6 +156,34 @@ func TestDBMPropagation(t *testing.T) {
 			},
 			executed: []*regexp.Regexp{regexp.MustCompile("/\\*dddbs='test.db',dde='test-env',ddps='test-service',ddpv='1.0.0',traceparent='00-00000000000000000000000000000001-[\\da-f]{16}-01'\\*/ SELECT 1 from DUAL")},
 		},
+		{
+			name: "query-full-no-injection",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeFull), WithDBMInjectionRate(0)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.QueryContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			executed: []*regexp.Regexp{regexp.MustCompile("^SELECT 1 from DUAL$")},
+		},
+		{
+			name: "query-full-parent",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeFull), WithDBMParentCorrelation(true)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.QueryContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			executed: []*regexp.Regexp{regexp.MustCompile("/\\*dddbs='test.db',dde='test-env',ddps='test-service',ddpv='1.0.0',traceparent='00-00000000000000000000000000000001-0000000000000001-01'\\*/ SELECT 1 from DUAL")},
+			parent:   true,
+		},
+		{
+			name: "query-service-override",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeService)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.QueryContext(WithServiceOverride(ctx, "other.db"), "SELECT 1 from DUAL")
+				return err
+			},
+			executed: []*regexp.Regexp{regexp.MustCompile("/\\*dddbs='other.db',dde='test-env',ddps='test-service',ddpv='1.0.0'\\*/ SELECT 1 from DUAL")},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -168,6 +211,9 @@ func TestDBMPropagation(t *testing.T) {
 			require.Len(t, d.Executed, len(tc.executed))
 			for i, e := range tc.executed {
 				assert.Regexp(t, e, d.Executed[i])
+				if tc.parent {
+					continue
+				}
 				// the injected span ID should not be the parent's span ID
 				assert.NotContains(t, d.Executed[i], "traceparent='00-00000000000000000000000000000001-0000000000000001")
 			}
@@ -253,6 +299,36 @@ func TestDBMTraceContextTagging(t *testing.T) {
 			spanType:                QueryTypeExec,
 			traceContextInjectedTag: true,
 		},
+		{
+			name: "query-per-type-service",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeFull), WithQueryTypeDBMPropagation(QueryTypeQuery, tracer.DBMPropagationModeService)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.QueryContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			spanType:                QueryTypeQuery,
+			traceContextInjectedTag: false,
+		},
+		{
+			name: "exec-per-type-full",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeService), WithQueryTypeDBMPropagation(QueryTypeExec, tracer.DBMPropagationModeFull)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.ExecContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			spanType:                QueryTypeExec,
+			traceContextInjectedTag: true,
+		},
+		{
+			name: "query-full-no-injection",
+			opts: []RegisterOption{WithDBMPropagation(tracer.DBMPropagationModeFull), WithDBMInjectionRate(0)},
+			callDB: func(ctx context.Context, db *sql.DB) error {
+				_, err := db.QueryContext(ctx, "SELECT 1 from DUAL")
+				return err
+			},
+			spanType:                QueryTypeQuery,
+			traceContextInjectedTag: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -297,3 +373,176 @@ func spansOfType(spans []mocktracer.Span, spanType string) (filtered []mocktrace
 	}
 	return filtered
 }
+
+func TestDBMInjectionError(t *testing.T) {
+	inject := injectQueryComments
+	defer func() { injectQueryComments = inject }()
+	injectQueryComments = func(c *tracer.SQLCommentCarrier, spanCtx ddtrace.SpanContext) error {
+		if c.Mode == tracer.DBMPropagationModeFull {
+			return errors.New("injection failure")
+		}
+		return inject(c, spanCtx)
+	}
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	d := &internal.MockDriver{}
+	Register("test", d, WithDBMPropagation(tracer.DBMPropagationModeFull))
+	defer unregister("test")
+
+	db, err := Open("test", "dn")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+
+	require.Len(t, d.Executed, 1)
+	// falls back to service mode
+	assert.Contains(t, d.Executed[0], "dddbs='test.db'")
+	assert.NotContains(t, d.Executed[0], "traceparent")
+
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeExec)
+	require.Len(t, spans, 1)
+	assert.Equal(t, true, spans[0].Tag(keyDBMInjectionError))
+	assert.Nil(t, spans[0].Tag(keyDBMTraceInjected))
+}
+
+func TestDBMTags(t *testing.T) {
+	d := &internal.MockDriver{}
+	Register("test", d, WithDBMPropagation(tracer.DBMPropagationModeService))
+	defer unregister("test")
+
+	db, err := Open("test", "dn")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := WithDBMTags(context.Background(), map[string]string{"flag": "new-ui", "experiment": "a"})
+	ctx = WithDBMTags(ctx, map[string]string{
+		"experiment":  "b",
+		"bad key":     "x",
+		"ddps":        "other",
+		"traceparent": "x",
+		"long":        strings.Repeat("x", maxDBMTagValueLength+1),
+		"invalid":     "\xff",
+	})
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	require.NoError(t, err)
+
+	require.Len(t, d.Executed, 1)
+	assert.True(t, strings.HasPrefix(d.Executed[0], "/*dddbs='test.db',"), d.Executed[0])
+	assert.True(t, strings.HasSuffix(d.Executed[0], ",experiment='b',flag='new-ui'*/ SELECT 1"), d.Executed[0])
+
+	t.Run("cap", func(t *testing.T) {
+		tags := make(map[string]string)
+		for i := 0; i < maxDBMTags+2; i++ {
+			tags[fmt.Sprintf("k%d", i)] = "v"
+		}
+		assert.Len(t, dbmTags(WithDBMTags(context.Background(), tags)), maxDBMTags)
+	})
+}
+
+func TestWithDBMNoSpanBehavior(t *testing.T) {
+	traceparent := regexp.MustCompile(`traceparent='00-[\da-f]{32}-([\da-f]{16})-\d{2}'`)
+	for _, tt := range []struct {
+		behavior DBMNoSpanBehavior
+		injected bool
+		orphan   bool
+	}{
+		{behavior: "", injected: true},
+		{behavior: DBMNoSpanRoot, injected: true},
+		{behavior: DBMNoSpanSkip},
+		{behavior: DBMNoSpanOrphan, injected: true, orphan: true},
+	} {
+		name := string(tt.behavior)
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			d := &internal.MockDriver{}
+			opts := []Option{WithDBMPropagation(tracer.DBMPropagationModeFull)}
+			if tt.behavior != "" {
+				opts = append(opts, WithDBMNoSpanBehavior(tt.behavior))
+			}
+			Register("test", d, opts...)
+			defer unregister("test")
+
+			db, err := Open("test", "dn")
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.ExecContext(context.Background(), "SELECT 1")
+			require.NoError(t, err)
+			root, ctx := tracer.StartSpanFromContext(context.Background(), "request")
+			_, err = db.ExecContext(ctx, "SELECT 2")
+			require.NoError(t, err)
+			root.Finish()
+
+			require.Len(t, d.Executed, 2)
+			assert.Contains(t, d.Executed[0], "dddbs='test.db'")
+			// queries run with a span are unaffected
+			assert.Regexp(t, traceparent, d.Executed[1])
+
+			spans := spansOfType(mt.FinishedSpans(), QueryTypeExec)
+			require.Len(t, spans, 2)
+			m := traceparent.FindStringSubmatch(d.Executed[0])
+			if !tt.injected {
+				assert.Nil(t, m)
+				assert.Nil(t, spans[0].Tag(keyDBMTraceInjected))
+				return
+			}
+			require.NotNil(t, m)
+			assert.Equal(t, true, spans[0].Tag(keyDBMTraceInjected))
+			spanID := fmt.Sprintf("%016x", spans[0].SpanID())
+			if tt.orphan {
+				assert.NotEqual(t, m[1], spanID)
+				assert.Equal(t, true, spans[0].Tag(keyDBMOrphan))
+			} else {
+				assert.Equal(t, m[1], spanID)
+				assert.Nil(t, spans[0].Tag(keyDBMOrphan))
+			}
+		})
+	}
+}
+
+func TestDBMOperationSampleRates(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		rate     float64
+		injected bool
+	}{
+		{name: "kept", rate: 1, injected: true},
+		{name: "dropped", rate: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			d := &internal.MockDriver{}
+			Register("test", d, WithDBMPropagation(tracer.DBMPropagationModeFull), WithOperationSampleRates(map[string]float64{"SELECT": tt.rate}))
+			defer unregister("test")
+
+			db, err := Open("test", "dn")
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.ExecContext(context.Background(), "SELECT 1")
+			require.NoError(t, err)
+
+			require.Len(t, d.Executed, 1)
+			assert.Contains(t, d.Executed[0], "dddbs='test.db'")
+			spans := spansOfType(mt.FinishedSpans(), QueryTypeExec)
+			if tt.injected {
+				assert.Contains(t, d.Executed[0], "traceparent=")
+				assert.Len(t, spans, 1)
+			} else {
+				assert.NotContains(t, d.Executed[0], "traceparent=")
+				assert.Len(t, spans, 0)
+			}
+		})
+	}
+}
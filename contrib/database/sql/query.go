@@ -0,0 +1,272 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"strings"
+)
+
+// nextToken returns the token of query starting at or after i, skipping whitespace and comments,
+// along with the index following the token. Words are returned as is, quoted identifiers and
+// string literals with their quotes (including Postgres dollar-quoted strings and E'...' strings with
+// backslash escapes), and any other character on its own. An empty token is returned at the end of
+// the query, along with -1 if the query ends with an unterminated comment, quoted identifier or
+// string literal.
+func nextToken(query string, i int) (token string, next int) {
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return "", len(query)
+			}
+			i += end + 1
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return "", -1
+			}
+			i += end + 4
+		case c == '$' && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				return "", -1
+			}
+			j := i + len(tag) + end + len(tag)
+			return query[i:j], j
+		case (c == 'E' || c == 'e') && strings.HasPrefix(query[i+1:], "'"):
+			j := i + 2
+			for j < len(query) {
+				switch query[j] {
+				case '\\':
+					j += 2
+				case '\'':
+					if j+1 < len(query) && query[j+1] == '\'' {
+						// escaped quote
+						j += 2
+						continue
+					}
+					return query[i : j+1], j + 1
+				default:
+					j++
+				}
+			}
+			return "", -1
+		case c == '"' || c == '`' || c == '\'':
+			j := i + 1
+			for j < len(query) {
+				if query[j] == c {
+					if j+1 < len(query) && query[j+1] == c {
+						// escaped quote
+						j += 2
+						continue
+					}
+					return query[i : j+1], j + 1
+				}
+				j++
+			}
+			return "", -1
+		case isWordChar(c):
+			j := i + 1
+			for j < len(query) && isWordChar(query[j]) {
+				j++
+			}
+			return query[i:j], j
+		default:
+			return query[i : i+1], i + 1
+		}
+	}
+	return "", len(query)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string which s starts with, e.g. $$ or
+// $tag$, or an empty string if s doesn't start with one, e.g. with a positional parameter like $1.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		if c == '$' {
+			return s[:j+1]
+		}
+		if !isWordChar(c) || j == 1 && c >= '0' && c <= '9' {
+			return ""
+		}
+	}
+	return ""
+}
+
+// isWord reports whether the given token is an unquoted word, such as a keyword or an identifier,
+// as opposed to a quoted identifier, a string literal or a positional parameter.
+func isWord(token string) bool {
+	return token != "" && isWordChar(token[0]) && token[0] != '$' && token[len(token)-1] != '\''
+}
+
+// identifier returns the name of the identifier token, without its quotes. It returns false if
+// the token is not an identifier.
+func identifier(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	switch c := token[0]; {
+	case c == '"' || c == '`':
+		name := token[1 : len(token)-1]
+		return strings.ReplaceAll(name, token[:1]+token[:1], token[:1]), name != ""
+	case isWord(token) && (c < '0' || c > '9'):
+		return token, true
+	}
+	return "", false
+}
+
+// queryTable returns the name of the main table of the given query: the table following the first
+// top-level FROM, INTO or UPDATE keyword. It returns false when the table can't be found with
+// certainty, e.g. when it comes from a subquery or when the query uses common table expressions.
+func queryTable(query string) (string, bool) {
+	depth := 0
+	for i, first := 0, true; ; first = false {
+		var token string
+		token, i = nextToken(query, i)
+		switch {
+		case token == "":
+			return "", false
+		case first && strings.EqualFold(token, "WITH"):
+			return "", false
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+		case depth == 0 && (strings.EqualFold(token, "FROM") || strings.EqualFold(token, "INTO") || strings.EqualFold(token, "UPDATE")):
+			return qualifiedName(query, i, strings.EqualFold(token, "FROM"))
+		}
+	}
+}
+
+// qualifiedName returns the possibly schema-qualified name starting at index i of query. If from is
+// true, the name is expected to follow a FROM keyword, where it can't be followed by parentheses.
+func qualifiedName(query string, i int, from bool) (string, bool) {
+	token, i := nextToken(query, i)
+	if strings.EqualFold(token, "ONLY") {
+		token, i = nextToken(query, i)
+	}
+	var parts []string
+	for {
+		name, ok := identifier(token)
+		if !ok || isKeyword(token) {
+			return "", false
+		}
+		parts = append(parts, name)
+		if token, i = nextToken(query, i); token != "." {
+			break
+		}
+		token, i = nextToken(query, i)
+	}
+	if from && token == "(" {
+		// function call, e.g. FROM generate_series(1, 10)
+		return "", false
+	}
+	return strings.Join(parts, "."), true
+}
+
+// isKeyword reports whether the given unquoted token is a keyword which can't be a table name.
+func isKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "SELECT", "LATERAL", "VALUES", "WHERE", "SET", "DEFAULT":
+		return true
+	}
+	return false
+}
+
+// queryVerb returns the upper-cased SQL verb of the given query, e.g. SELECT or INSERT. For queries
+// using common table expressions, it is the verb of the main statement following them. It returns
+// an empty string when no verb is found.
+func queryVerb(query string) string {
+	depth, cte := 0, false
+	for i, first := 0, true; ; first = false {
+		var token string
+		token, i = nextToken(query, i)
+		switch {
+		case token == "":
+			return ""
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+		case first && strings.EqualFold(token, "WITH"):
+			cte = true
+		case !cte:
+			if !isWord(token) {
+				return ""
+			}
+			return strings.ToUpper(token)
+		case depth == 0 && isVerb(token):
+			return strings.ToUpper(token)
+		}
+	}
+}
+
+// isVerb reports whether the given unquoted token is a verb which can follow common table expressions.
+func isVerb(token string) bool {
+	switch strings.ToUpper(token) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE":
+		return true
+	}
+	return false
+}
+
+// isFullTableMutation reports whether the given query is an UPDATE or DELETE statement without WHERE
+// clause, which modifies all the rows of its table. It only returns true when certain, e.g. it returns
+// false for queries using common table expressions, holding several statements or using LIMIT.
+func isFullTableMutation(query string) bool {
+	token, i := nextToken(query, 0)
+	if !strings.EqualFold(token, "UPDATE") && !strings.EqualFold(token, "DELETE") {
+		return false
+	}
+	depth := 0
+	for {
+		token, i = nextToken(query, i)
+		switch {
+		case token == "":
+			return i == len(query) && depth == 0
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+		case token == ";":
+			// only a trailing semicolon is allowed
+			if next, _ := nextToken(query, i); next != "" {
+				return false
+			}
+		case depth == 0 && (strings.EqualFold(token, "WHERE") || strings.EqualFold(token, "LIMIT")):
+			return false
+		}
+	}
+}
+
+// isSessionConfig reports whether the given query is a session configuration statement: SET,
+// RESET or a SELECT calling set_config, e.g. SELECT set_config('search_path', 'app', false).
+func isSessionConfig(query string) bool {
+	token, i := nextToken(query, 0)
+	switch {
+	case strings.EqualFold(token, "SET") || strings.EqualFold(token, "RESET"):
+		return true
+	case strings.EqualFold(token, "SELECT"):
+		token, i = nextToken(query, i)
+		if strings.EqualFold(token, "pg_catalog") {
+			if token, i = nextToken(query, i); token != "." {
+				return false
+			}
+			token, i = nextToken(query, i)
+		}
+		next, _ := nextToken(query, i)
+		return strings.EqualFold(token, "set_config") && next == "("
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTable(t *testing.T) {
+	for query, want := range map[string]string{
+		"SELECT * FROM users WHERE id = $1":                            "users",
+		"select a, b from public.users u join orders o on u.id = o.id": "public.users",
+		`SELECT * FROM "My Schema"."My ""Table"""`:                     `My Schema.My "Table"`,
+		"SELECT * FROM `users`":                                        "users",
+		"SELECT EXTRACT(YEAR FROM ts), (SELECT 1 FROM b) FROM a":       "a",
+		"INSERT INTO users(id, name) VALUES ($1, $2)":                  "users",
+		"INSERT INTO users (id) VALUES (1)":                            "users",
+		"UPDATE ONLY accounts SET balance = 0":                         "accounts",
+		"DELETE FROM sessions WHERE expires_at < now()":                "sessions",
+		"/* comment */ SELECT 'FROM x' FROM -- FROM y\n t":             "t",
+		"SELECT 1":                                          "",
+		"SELECT * FROM (SELECT 1) sub":                      "",
+		"SELECT * FROM generate_series(1, 10)":              "",
+		"WITH cte AS (SELECT 1) SELECT * FROM cte":          "",
+		"SELECT * FROM LATERAL (SELECT 1) l":                "",
+		"SELECT * FROM":                                     "",
+		"SELECT 'unterminated FROM users":                   "",
+		"SELECT * FROM /* unterminated comment":             "",
+		"SELECT * FROM schema.":                             "",
+		`SELECT * FROM ""`:                                  "",
+		"BEGIN":                                             "",
+		"UPDATE users SET name = (SELECT name FROM others)": "users",
+		"SELECT $$ from x $$":                               "",
+		"SELECT $tag$ FROM $$ x $tag$ FROM t WHERE a = $1":  "t",
+		"SELECT $$ unterminated FROM t":                     "",
+		`SELECT E'it\'s FROM x' FROM t`:                     "t",
+		`SELECT e'\\' FROM t`:                               "t",
+		"SELECT * FROM $$t$$":                               "",
+		"SELECT * FROM E't'":                                "",
+	} {
+		t.Run(query, func(t *testing.T) {
+			name, ok := queryTable(query)
+			assert.Equal(t, want != "", ok)
+			assert.Equal(t, want, name)
+		})
+	}
+}
+
+func TestQueryVerb(t *testing.T) {
+	for query, want := range map[string]string{
+		"SELECT * FROM users":                                                       "SELECT",
+		"  insert into users values (1)":                                            "INSERT",
+		"/* comment */ update users set a = 1":                                      "UPDATE",
+		"(SELECT 1) UNION (SELECT 2)":                                               "SELECT",
+		"WITH cte AS (SELECT 1) DELETE FROM t USING cte":                            "DELETE",
+		"WITH RECURSIVE t(n) AS (SELECT 1 UNION SELECT n+1 FROM t) SELECT n FROM t": "SELECT",
+		"VACUUM":                 "VACUUM",
+		"WITH cte AS (SELECT 1)": "",
+		"":                       "",
+		"; SELECT 1":             "",
+		"$$SELECT$$":             "",
+		"E'SELECT'":              "",
+	} {
+		t.Run(query, func(t *testing.T) {
+			assert.Equal(t, want, queryVerb(query))
+		})
+	}
+}
+
+func TestIsFullTableMutation(t *testing.T) {
+	for query, want := range map[string]bool{
+		"DELETE FROM sessions":                                      true,
+		"update users set active = false;":                          true,
+		"UPDATE users SET name = (SELECT name FROM x WHERE id = 1)": true,
+		"UPDATE t SET a = 1 FROM other":                             true,
+		"DELETE FROM sessions WHERE expires_at < now()":             false,
+		"DELETE FROM t -- WHERE id = 1\n":                           true,
+		"DELETE FROM t /* WHERE id = 1 */ WHERE id = 1":             false,
+		"DELETE FROM t LIMIT 10":                                    false,
+		"DELETE FROM t; DELETE FROM u WHERE id = 1":                 false,
+		"WITH x AS (SELECT 1) DELETE FROM t":                        false,
+		"SELECT * FROM t":                                           false,
+		"TRUNCATE t":                                                false,
+		"DELETE FROM t WHERE a = 'unterminated":                     false,
+		"DELETE FROM 'unterminated":                                 false,
+		"UPDATE t SET a = $$ WHERE $$":                              true,
+		"UPDATE t SET a = $x$ $$ WHERE $$ $x$":                      true,
+		"DELETE FROM t WHERE a = $body$x$body$":                     false,
+		`UPDATE t SET a = E'\' WHERE b = 1'`:                        true,
+		"UPDATE t SET a = $$ WHERE":                                 false,
+	} {
+		t.Run(query, func(t *testing.T) {
+			assert.Equal(t, want, isFullTableMutation(query))
+		})
+	}
+}
+
+func TestIsSessionConfig(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SET search_path TO app":                             true,
+		"set local statement_timeout = '5s'":                 true,
+		"/* comment */ RESET ALL":                            true,
+		"SELECT set_config('search_path', 'app', false)":     true,
+		"select pg_catalog.set_config('app.user', $1, true)": true,
+		"SELECT set_config":                                  false,
+		"SELECT * FROM settings":                             false,
+		"UPDATE t SET a = 1":                                 false,
+		"SELECT 'SET'":                                       false,
+		"":                                                   false,
+	} {
+		t.Run(query, func(t *testing.T) {
+			assert.Equal(t, want, isSessionConfig(query))
+		})
+	}
+}
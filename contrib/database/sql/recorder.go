@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"sync"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+)
+
+// RecordedSpan holds the data of a span recorded by a SpanRecorder.
+type RecordedSpan struct {
+	// OperationName is the operation name of the span, e.g. postgres.query.
+	OperationName string
+	// Tags holds the tags of the span, including the service name (ext.ServiceName), the resource
+	// name (ext.ResourceName), the span type (ext.SpanType) and the error (ext.Error), if any.
+	Tags map[string]interface{}
+}
+
+// SpanRecorder records the spans created by this package, for users to check in their tests that
+// their data layer produces the expected spans, without starting a tracer or a mock tracer. It is
+// safe for concurrent use. See WithSpanRecorder.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// Spans returns the spans recorded so far, in the order they were finished.
+func (r *SpanRecorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]RecordedSpan, len(r.spans))
+	copy(spans, r.spans)
+	return spans
+}
+
+// Reset discards the spans recorded so far.
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+// record wraps the given span so that it is recorded once finished, along with the tags set by opts.
+func (r *SpanRecorder) record(span ddtrace.Span, name string, opts []ddtrace.StartSpanOption) ddtrace.Span {
+	var cfg ddtrace.StartSpanConfig
+	for _, fn := range opts {
+		fn(&cfg)
+	}
+	tags := make(map[string]interface{}, len(cfg.Tags))
+	for k, v := range cfg.Tags {
+		tags[k] = v
+	}
+	return &recordedSpan{Span: span, recorder: r, data: RecordedSpan{OperationName: name, Tags: tags}}
+}
+
+// recordedSpan is a span recorded by a SpanRecorder when finished.
+type recordedSpan struct {
+	ddtrace.Span
+	recorder *SpanRecorder
+	data     RecordedSpan
+}
+
+// SetTag implements ddtrace.Span.
+func (s *recordedSpan) SetTag(key string, value interface{}) {
+	s.Span.SetTag(key, value)
+	s.data.Tags[key] = value
+}
+
+// Finish implements ddtrace.Span.
+func (s *recordedSpan) Finish(opts ...ddtrace.FinishOption) {
+	s.Span.Finish(opts...)
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.spans = append(s.recorder.spans, s.data)
+}
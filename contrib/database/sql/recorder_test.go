@@ -0,0 +1,48 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package sql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithSpanRecorder(t *testing.T) {
+	Register("test", &internal.MockDriver{Err: errors.New("boom")})
+	defer unregister("test")
+
+	var r SpanRecorder
+	db, err := Open("test", "dn", WithSpanRecorder(&r), WithServiceName("my-db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := WithSpanTags(context.Background(), map[string]string{"team": "payments"})
+	_, err = db.ExecContext(ctx, "UPDATE t SET a = 1")
+	require.Error(t, err)
+
+	spans := r.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "test.query", spans[0].OperationName)
+	assert.Equal(t, "Connect", spans[0].Tags[ext.ResourceName])
+	s := spans[1]
+	assert.Equal(t, "test.query", s.OperationName)
+	assert.Equal(t, "my-db", s.Tags[ext.ServiceName])
+	assert.Equal(t, "UPDATE t SET a = 1", s.Tags[ext.ResourceName])
+	assert.Equal(t, ext.SpanTypeSQL, s.Tags[ext.SpanType])
+	assert.Equal(t, "Exec", s.Tags["sql.query_type"])
+	assert.Equal(t, "payments", s.Tags["team"])
+	assert.EqualError(t, s.Tags[ext.Error].(error), "boom")
+
+	r.Reset()
+	assert.Empty(t, r.Spans())
+}
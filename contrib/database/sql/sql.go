@@ -20,10 +20,13 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/telemetry"
@@ -126,6 +129,9 @@ func Register(driverName string, driver driver.Driver, opts ...RegisterOption) {
 	for _, fn := range opts {
 		fn(cfg)
 	}
+	if err := cfg.validate(); err != nil {
+		log.Warn("contrib/database/sql: %v", err)
+	}
 	log.Debug("contrib/database/sql: Registering driver: %s %#v", driverName, cfg)
 	registeredDrivers.add(driverName, driver, cfg)
 }
@@ -153,17 +159,36 @@ func (t *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
 	} else if t.cfg.dsn != "" {
 		tp.meta, _ = internal.ParseDSN(t.driverName, t.cfg.dsn)
 	}
+	tp.meta = withPeerTags(tp.meta, t.cfg.peerTags)
 	start := time.Now()
 	ctx, end := startTraceTask(ctx, string(QueryTypeConnect))
 	defer end()
 	conn, err := t.connector.Connect(ctx)
-	tp.tryTrace(ctx, QueryTypeConnect, "", start, err)
+	err = tp.tryTrace(ctx, QueryTypeConnect, "", start, err)
 	if err != nil {
 		return nil, err
 	}
 	return &TracedConn{conn, tp}, err
 }
 
+// withPeerTags returns meta extended with the peer tags derived from it, followed by the
+// user provided tags which take precedence.
+func withPeerTags(meta map[string]string, tags map[string]string) map[string]string {
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	if host, ok := meta[ext.TargetHost]; ok {
+		meta[ext.PeerHostname] = host
+	}
+	if name, ok := meta[ext.DBName]; ok {
+		meta[ext.DBInstance] = name
+	}
+	for k, v := range tags {
+		meta[k] = v
+	}
+	return meta
+}
+
 func (t *tracedConnector) Driver() driver.Driver {
 	return t.connector.Driver()
 }
@@ -186,6 +211,19 @@ func (t dsnConnector) Driver() driver.Driver {
 // first be registered using Register. If this did not occur, OpenDB will determine the driver name
 // based on its type.
 func OpenDB(c driver.Connector, opts ...Option) *sql.DB {
+	tc, err := newTracedConnector(c, opts...)
+	if err != nil {
+		log.Warn("contrib/database/sql: %v", err)
+	}
+	return sql.OpenDB(tc)
+}
+
+// poolCount is the number of generated pool ids, see WithPoolID.
+var poolCount uint64
+
+// newTracedConnector returns the traced version of the given connector, along with an error
+// if the configuration resulting from opts is invalid.
+func newTracedConnector(c driver.Connector, opts ...Option) (*tracedConnector, error) {
 	cfg := new(config)
 	var driverName string
 	if name, ok := registeredDrivers.name(c.Driver()); ok {
@@ -199,18 +237,23 @@ func OpenDB(c driver.Connector, opts ...Option) *sql.DB {
 	for _, fn := range opts {
 		fn(cfg)
 	}
+	if cfg.poolIDEnabled && cfg.poolID == "" {
+		cfg.poolID = driverName + "-" + strconv.FormatUint(atomic.AddUint64(&poolCount, 1), 10)
+	}
+	cfg.startOpts = cfg.staticSpanOptions(driverName)
 	tc := &tracedConnector{
 		connector:  c,
 		driverName: driverName,
 		cfg:        cfg,
 	}
-	return sql.OpenDB(tc)
+	return tc, cfg.validate()
 }
 
 // Open returns connection to a DB using the traced version of the given driver. The driver may
 // first be registered using Register. If this did not occur, Open will determine the driver by
 // opening a DB connection and retrieving the driver using (*sql.DB).Driver, before closing it and
-// opening a new, traced connection.
+// opening a new, traced connection. A *ConfigError is returned if opts result in an invalid
+// configuration.
 func Open(driverName, dataSourceName string, opts ...Option) (*sql.DB, error) {
 	var d driver.Driver
 	if registeredDrivers.isRegistered(driverName) {
@@ -232,7 +275,16 @@ func Open(driverName, dataSourceName string, opts ...Option) (*sql.DB, error) {
 		}
 		// since we're not using the dsnConnector, we need to register the dsn manually in the config
 		opts = append(opts, WithDSN(dataSourceName))
-		return OpenDB(connector, opts...), nil
+		return openDB(connector, opts...)
+	}
+	return openDB(&dsnConnector{dsn: dataSourceName, driver: d}, opts...)
+}
+
+// openDB is like OpenDB, but returns an error instead of logging it if the configuration is invalid.
+func openDB(c driver.Connector, opts ...Option) (*sql.DB, error) {
+	tc, err := newTracedConnector(c, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return OpenDB(&dsnConnector{dsn: dataSourceName, driver: d}, opts...), nil
+	return sql.OpenDB(tc), nil
 }
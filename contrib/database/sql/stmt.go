@@ -10,6 +10,9 @@ import (
 	"database/sql/driver"
 	"errors"
 	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 var _ driver.Stmt = (*tracedStmt)(nil)
@@ -20,6 +23,9 @@ type tracedStmt struct {
 	*traceParams
 	ctx   context.Context
 	query string
+	// executions is the number of times the statement was executed. Driver statements belong
+	// to a single connection and aren't used concurrently.
+	executions int
 }
 
 // Close sends a span before closing a statement
@@ -28,18 +34,19 @@ func (s *tracedStmt) Close() (err error) {
 	ctx, end := startTraceTask(s.ctx, QueryTypeClose)
 	defer end()
 	err = s.Stmt.Close()
-	s.tryTrace(ctx, QueryTypeClose, "", start, err)
+	err = s.tryTrace(ctx, QueryTypeClose, "", start, err)
 	return err
 }
 
 // ExecContext is needed to implement the driver.StmtExecContext interface
 func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
 	start := time.Now()
+	qtype := s.queryType(QueryTypeExec, s.query)
 	if stmtExecContext, ok := s.Stmt.(driver.StmtExecContext); ok {
-		ctx, end := startTraceTask(ctx, QueryTypeExec)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		res, err := stmtExecContext.ExecContext(ctx, args)
-		s.tryTrace(ctx, QueryTypeExec, s.query, start, err)
+		err = s.tryTrace(ctx, qtype, s.query, start, err, append(s.paramHashTag(args), s.executionTag())...)
 		return res, err
 	}
 	dargs, err := namedValueToValue(args)
@@ -51,21 +58,22 @@ func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue)
 		return nil, ctx.Err()
 	default:
 	}
-	ctx, end := startTraceTask(ctx, QueryTypeExec)
+	ctx, end := startTraceTask(ctx, string(qtype))
 	defer end()
 	res, err = s.Exec(dargs)
-	s.tryTrace(ctx, QueryTypeExec, s.query, start, err)
+	err = s.tryTrace(ctx, qtype, s.query, start, err, append(s.paramHashTag(args), s.executionTag())...)
 	return res, err
 }
 
 // QueryContext is needed to implement the driver.StmtQueryContext interface
 func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
 	start := time.Now()
+	qtype := s.queryType(QueryTypeQuery, s.query)
 	if stmtQueryContext, ok := s.Stmt.(driver.StmtQueryContext); ok {
-		ctx, end := startTraceTask(ctx, QueryTypeQuery)
+		ctx, end := startTraceTask(ctx, string(qtype))
 		defer end()
 		rows, err := stmtQueryContext.QueryContext(ctx, args)
-		s.tryTrace(ctx, QueryTypeQuery, s.query, start, err)
+		err = s.tryTrace(ctx, qtype, s.query, start, err, append(append(s.resultColumnTags(rows, err), s.paramHashTag(args)...), s.executionTag())...)
 		return rows, err
 	}
 	dargs, err := namedValueToValue(args)
@@ -77,13 +85,21 @@ func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue)
 		return nil, ctx.Err()
 	default:
 	}
-	ctx, end := startTraceTask(ctx, QueryTypeQuery)
+	ctx, end := startTraceTask(ctx, string(qtype))
 	defer end()
 	rows, err = s.Query(dargs)
-	s.tryTrace(ctx, QueryTypeQuery, s.query, start, err)
+	err = s.tryTrace(ctx, qtype, s.query, start, err, append(append(s.resultColumnTags(rows, err), s.paramHashTag(args)...), s.executionTag())...)
 	return rows, err
 }
 
+// executionTag counts an execution of the statement, and returns the span option tagging it with
+// the number of executions so far, which shows how well prepared statements are reused. Statements
+// aren't tagged with a name, as driver.Stmt doesn't expose the one the driver may have given them.
+func (s *tracedStmt) executionTag() ddtrace.StartSpanOption {
+	s.executions++
+	return tracer.Tag(keyStmtExecutions, s.executions)
+}
+
 // copied from stdlib database/sql package: src/database/sql/ctxutil.go
 func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
 	dargs := make([]driver.Value, len(named))
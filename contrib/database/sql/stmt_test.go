@@ -9,7 +9,11 @@ import (
 	"context"
 	"testing"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
 	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,3 +35,55 @@ func TestUseStatementContext(t *testing.T) {
 	var result int
 	require.NoError(t, stmt.QueryRowContext(ctx2).Scan(&result))
 }
+
+func TestWithResultColumnTags(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	Register("sqlite3-columns", &sqlite3.SQLiteDriver{})
+	defer unregister("sqlite3-columns")
+	db, err := Open("sqlite3-columns", "file::memory:?cache=shared", WithResultColumnTags(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS columns_test (id INTEGER, name TEXT)")
+	require.NoError(t, err)
+	stmt, err := db.Prepare("SELECT id, name FROM columns_test")
+	require.NoError(t, err)
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	require.NoError(t, err)
+	rows.Close()
+
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeQuery)
+	require.Len(t, spans, 1)
+	assert.Equal(t, 2, spans[0].Tag(keyResultColumnCount))
+	assert.Equal(t, "INTEGER,TEXT", spans[0].Tag(keyResultColumnTypes))
+}
+
+func TestStatementExecutions(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	Register("test", &internal.MockDriver{})
+	defer unregister("test")
+	db, err := Open("test", "dn")
+	require.NoError(t, err)
+	defer db.Close()
+	// keep a single connection so that the statement is prepared once
+	db.SetMaxOpenConns(1)
+
+	stmt, err := db.Prepare("UPDATE t SET a = 1")
+	require.NoError(t, err)
+	defer stmt.Close()
+	for i := 0; i < 3; i++ {
+		_, err = stmt.Exec()
+		require.NoError(t, err)
+	}
+
+	spans := spansOfType(mt.FinishedSpans(), QueryTypeExec)
+	require.Len(t, spans, 3)
+	for i, s := range spans {
+		assert.Equal(t, i+1, s.Tag(keyStmtExecutions))
+	}
+}
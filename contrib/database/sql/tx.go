@@ -11,6 +11,8 @@ import (
 	"runtime/trace"
 	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 )
 
@@ -48,7 +50,12 @@ func (t *tracedTx) Commit() (err error) {
 
 	start := time.Now()
 	err = t.Tx.Commit()
-	t.tryTrace(ctx, QueryTypeCommit, "", start, err)
+	var opts []ddtrace.StartSpanOption
+	if threshold := t.cfg.slowCommitThreshold; threshold > 0 {
+		opts = append(opts, tracer.Tag(keyCommitSlow, time.Since(start) >= threshold))
+	}
+	err = t.tryTrace(ctx, QueryTypeCommit, "", start, err, opts...)
+	t.inTx = false
 	return err
 }
 
@@ -59,6 +66,7 @@ func (t *tracedTx) Rollback() (err error) {
 
 	start := time.Now()
 	err = t.Tx.Rollback()
-	t.tryTrace(ctx, QueryTypeRollback, "", start, err)
+	err = t.tryTrace(ctx, QueryTypeRollback, "", start, err)
+	t.inTx = false
 	return err
 }
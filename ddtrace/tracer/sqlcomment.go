@@ -6,11 +6,13 @@
 package tracer
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/samplernames"
@@ -69,12 +71,22 @@ type SQLCommentCarrier struct {
 	Query         string
 	Mode          DBMPropagationMode
 	DBServiceName string
-	SpanID        uint64
+	// SpanID is the span ID propagated in the trace comment. If it is zero when calling Inject,
+	// a new span ID is generated and stored, for the span of the query to use it.
+	SpanID uint64
+	// Tags holds additional tags injected in the comment along with the DBM ones, unless propagation
+	// is disabled. Tags using the keys of DBM tags are ignored.
+	Tags map[string]string
+	// Sampled is set by Inject in full mode, and reports whether the propagated trace context was
+	// sampled, i.e. whether the trace is known to be kept.
+	Sampled bool
 }
 
 // Inject injects a span context in the carrier's Query field as a comment.
 func (c *SQLCommentCarrier) Inject(spanCtx ddtrace.SpanContext) error {
-	c.SpanID = generateSpanID(now())
+	if c.SpanID == 0 {
+		c.SpanID = generateSpanID(now())
+	}
 	tags := make(map[string]string)
 	switch c.Mode {
 	case DBMPropagationModeUndefined:
@@ -96,6 +108,7 @@ func (c *SQLCommentCarrier) Inject(spanCtx ddtrace.SpanContext) error {
 			traceID = c.SpanID
 		}
 		tags[sqlCommentTraceParent] = encodeTraceParent(traceID, c.SpanID, sampled)
+		c.Sampled = sampled == 1
 		fallthrough
 	case DBMPropagationModeService:
 		if ctx, ok := spanCtx.(*spanContext); ok {
@@ -106,15 +119,35 @@ func (c *SQLCommentCarrier) Inject(spanCtx ddtrace.SpanContext) error {
 				tags[sqlCommentParentVersion] = v
 			}
 		}
+		if _, ok := tags[sqlCommentEnv]; !ok {
+			// no parent span, fall back to the env of the global tracer
+			if t, ok := internal.GetGlobalTracer().(*tracer); ok && t.config.env != "" {
+				tags[sqlCommentEnv] = t.config.env
+			}
+		}
 		if globalconfig.ServiceName() != "" {
 			tags[sqlCommentParentService] = globalconfig.ServiceName()
 		}
 		tags[sqlCommentDBService] = c.DBServiceName
 	}
+	for k, v := range c.Tags {
+		if _, ok := tags[k]; !ok && !isSQLCommentKey(k) {
+			tags[k] = v
+		}
+	}
 	c.Query = commentQuery(c.Query, tags)
 	return nil
 }
 
+// isSQLCommentKey reports whether the given key is used by the tags injected for DBM.
+func isSQLCommentKey(k string) bool {
+	switch k {
+	case sqlCommentTraceParent, sqlCommentParentService, sqlCommentDBService, sqlCommentParentVersion, sqlCommentEnv:
+		return true
+	}
+	return false
+}
+
 // encodeTraceParent encodes trace parent as per the w3c trace context spec (https://www.w3.org/TR/trace-context/#version).
 func encodeTraceParent(traceID uint64, spanID uint64, sampled int64) string {
 	var b strings.Builder
@@ -153,9 +186,19 @@ func commentQuery(query string, tags map[string]string) string {
 		return ""
 	}
 	var b strings.Builder
-	// the sqlcommenter specification dictates that tags should be sorted. Since we know all injected keys,
-	// we skip a sorting operation by specifying the order of keys statically
+	// the sqlcommenter specification dictates that tags should be sorted. Unless additional tags were
+	// given, we know all injected keys and skip a sorting operation by specifying the order of keys statically
 	orderedKeys := []string{sqlCommentDBService, sqlCommentEnv, sqlCommentParentService, sqlCommentParentVersion, sqlCommentTraceParent}
+	for k := range tags {
+		if !isSQLCommentKey(k) {
+			orderedKeys = make([]string, 0, len(tags))
+			for k := range tags {
+				orderedKeys = append(orderedKeys, k)
+			}
+			sort.Strings(orderedKeys)
+			break
+		}
+	}
 	first := true
 	for _, k := range orderedKeys {
 		if v, ok := tags[k]; ok {
@@ -117,6 +117,9 @@ func TestSQLCommentCarrier(t *testing.T) {
 			require.NoError(t, err)
 			expected := strings.ReplaceAll(tc.expectedQuery, "<span_id>", fmt.Sprintf("%016s", strconv.FormatUint(carrier.SpanID, 16)))
 			assert.Equal(t, expected, carrier.Query)
+			if tc.mode == DBMPropagationModeFull {
+				assert.Equal(t, tc.samplingPriority > 0, carrier.Sampled)
+			}
 
 			if !tc.injectSpan {
 				traceID = carrier.SpanID
@@ -141,6 +144,46 @@ func TestSQLCommentCarrier(t *testing.T) {
 	}
 }
 
+func TestSQLCommentCarrierSpanID(t *testing.T) {
+	tracer := newTracer()
+	defer tracer.Stop()
+
+	root := tracer.StartSpan("service.calling.db", WithSpanID(10)).(*span)
+	carrier := SQLCommentCarrier{Query: "SELECT * from FOO", Mode: DBMPropagationModeFull, DBServiceName: "whiskey-db", SpanID: 10}
+	err := carrier.Inject(root.Context())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), carrier.SpanID)
+	assert.Contains(t, carrier.Query, "traceparent='00-0000000000000000000000000000000a-000000000000000a-")
+}
+
+func TestSQLCommentCarrierGlobalEnv(t *testing.T) {
+	Start(WithEnv("test-env"))
+	defer Stop()
+
+	carrier := SQLCommentCarrier{Query: "SELECT * from FOO", Mode: DBMPropagationModeService, DBServiceName: "whiskey-db"}
+	err := carrier.Inject(nil)
+	require.NoError(t, err)
+	assert.Contains(t, carrier.Query, "dde='test-env'")
+}
+
+func TestSQLCommentCarrierTags(t *testing.T) {
+	tags := map[string]string{"flag": "new ui", "dddbs": "other-db", "abc": "*/ DROP TABLE FOO"}
+	t.Run("service", func(t *testing.T) {
+		carrier := SQLCommentCarrier{Query: "SELECT * from FOO", Mode: DBMPropagationModeService, DBServiceName: "whiskey-db", Tags: tags}
+		err := carrier.Inject(nil)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(carrier.Query, "/*abc='%2A%2F%20DROP%20TABLE%20FOO',dddbs='whiskey-db',ddps="))
+		assert.True(t, strings.HasSuffix(carrier.Query, ",flag='new%20ui'*/ SELECT * from FOO"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		carrier := SQLCommentCarrier{Query: "SELECT * from FOO", Mode: DBMPropagationModeDisabled, DBServiceName: "whiskey-db", Tags: tags}
+		err := carrier.Inject(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT * from FOO", carrier.Query)
+	})
+}
+
 func TestExtractOpenTelemetryTraceInformation(t *testing.T) {
 	// open-telemetry supports 128 bit trace ids
 	traceID := "5bd66ef5095369c7b0d1f8f4bd33716a"